@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
-	"os"
+	"time"
 
 	_ "ecommerce-backend/docs"
 	"ecommerce-backend/internal/config"
 	"ecommerce-backend/internal/database"
 	"ecommerce-backend/internal/handlers"
+	"ecommerce-backend/internal/jobs"
+	"ecommerce-backend/internal/media"
 	"ecommerce-backend/internal/middleware"
+	"ecommerce-backend/internal/notifications"
+	"ecommerce-backend/internal/storage"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -25,9 +30,47 @@ func main() {
 	db := database.NewConnection(cfg.GetDBConnectionString())
 	defer db.Close()
 
-	// Create uploads directory if it doesn't exist
-	if err := os.MkdirAll(cfg.UploadPath, 0755); err != nil {
-		log.Fatal("Failed to create uploads directory:", err)
+	// Upload storage: local directory or S3-compatible bucket
+	store, err := storage.New(cfg.StorageDriver, cfg.UploadPath, cfg.UploadBaseURL, storage.S3Config{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		Bucket:          cfg.S3Bucket,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		PathStyle:       cfg.S3PathStyle,
+		PublicURL:       cfg.S3PublicURL,
+		SignedURLTTL:    cfg.S3SignedURLTTL,
+	})
+	if err != nil {
+		log.Fatal("Failed to set up upload storage:", err)
+	}
+
+	// Background jobs
+	notifier := notifications.NewNotifier(cfg.Notifier, cfg.NotifyWebhookURL)
+	abandonedCarts := &jobs.AbandonedCartJob{
+		DB:          db.DB,
+		Notifier:    notifier,
+		IdleAfter:   cfg.AbandonedCartIdle,
+		FrontendURL: cfg.FrontendURL,
+		JWTSecret:   cfg.JWTSecret,
+	}
+	go jobs.Every(context.Background(), "abandoned-carts", cfg.AbandonedCartScan, abandonedCarts.Run)
+	go jobs.Every(context.Background(), "expire-reservations", time.Minute, jobs.ReleaseExpiredReservations(db.DB))
+	go jobs.Every(context.Background(), "expire-loyalty-points", time.Hour, jobs.ExpireLoyaltyPoints(db.DB))
+	go jobs.Every(context.Background(), "refresh-co-purchases", cfg.CoPurchaseRefresh, jobs.RefreshCoPurchases(db.DB))
+	lowStock := &jobs.LowStockJob{
+		DB:               db.DB,
+		Notifier:         notifier,
+		DefaultThreshold: cfg.LowStockThreshold,
+		DigestInterval:   cfg.LowStockDigest,
+		FrontendURL:      cfg.FrontendURL,
+	}
+	go jobs.Every(context.Background(), "low-stock-alerts", cfg.LowStockCheck, lowStock.Check)
+	// Digest checks hourly whether one is due; shorter intervals check as often
+	if cfg.LowStockDigest > 0 {
+		go jobs.Every(context.Background(), "low-stock-digest", min(cfg.LowStockDigest, time.Hour), lowStock.Digest)
+	} else {
+		log.Printf("Low-stock digest disabled: LOW_STOCK_DIGEST_INTERVAL must be positive")
 	}
 
 	// Initialize Fiber app
@@ -50,9 +93,10 @@ func main() {
 		Format: "[${ip}]:${port} ${status} - ${method} ${path}\n",
 	}))
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:3000, http://localhost:5173, https://go-ecom.vercel.app",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowOrigins:  "http://localhost:3000, http://localhost:5173, https://go-ecom.vercel.app",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:  "Origin,Content-Type,Accept,Authorization,X-Cart-Token",
+		ExposeHeaders: "X-Cart-Token",
 	}))
 
 	// Global debug middleware: log all requests and bodies
@@ -78,27 +122,166 @@ func main() {
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db.DB, cfg.JWTSecret)
 	userHandler := handlers.NewUserHandler(db.DB)
-	productHandler := handlers.NewProductHandler(db.DB, cfg.UploadPath)
-	orderHandler := handlers.NewOrderHandler(db.DB)
-	mpesaHandler := handlers.NewMpesaHandler(db.DB)
+	productHandler := handlers.NewProductHandler(db.DB, media.NewProcessor(store, cfg.UploadBaseURL, cfg.UploadMaxBytes))
+	loyalty := handlers.LoyaltyProgram{
+		PointsPerKES: cfg.LoyaltyPointsPerKES,
+		PointValue:   cfg.LoyaltyPointValue,
+		PointsTTL:    cfg.LoyaltyPointsExpiry,
+	}
+	orderHandler := handlers.NewOrderHandler(db.DB, cfg.ReservationTTL, loyalty)
+	cartHandler := handlers.NewCartHandler(db.DB, cfg.JWTSecret, cfg.ReservationTTL, loyalty)
+	reportHandler := handlers.NewReportHandler(db.DB)
+	couponHandler := handlers.NewCouponHandler(db.DB)
+	promotionHandler := handlers.NewPromotionHandler(db.DB)
+	walletHandler := handlers.NewWalletHandler(db.DB)
+	loyaltyHandler := handlers.NewLoyaltyHandler(db.DB, loyalty)
+	searchHandler := handlers.NewSearchHandler(db.DB)
+	categoryHandler := handlers.NewCategoryHandler(db.DB)
+	reviewHandler := handlers.NewReviewHandler(db.DB)
+	wishlistHandler := handlers.NewWishlistHandler(db.DB, cartHandler, cfg.FrontendURL)
+	inventoryHandler := handlers.NewInventoryHandler(db.DB, cfg.LowStockThreshold)
+	locationHandler := handlers.NewLocationHandler(db.DB)
+	mpesaHandler := handlers.NewMpesaHandler(db.DB, cfg.MpesaWebhookSecret)
 
 	// API routes
 	api := app.Group("/api")
 	// Endpoint to list all products
-	api.Get("/products", productHandler.GetProducts)
-	api.Get("/products/:id", productHandler.GetProduct)
-	api.Post("/products", productHandler.CreateProduct)
-	api.Delete("/products/:id", productHandler.DeleteProduct)
+	api.Get("/products", middleware.OptionalAuth(cfg.JWTSecret), productHandler.GetProducts)
+	api.Get("/products/search", searchHandler.SearchProducts)
+	api.Get("/products/suggest", searchHandler.Suggest)
+	api.Get("/products/:id", middleware.OptionalAuth(cfg.JWTSecret), productHandler.GetProduct)
+	// Catalogue changes are admin only
+	api.Post("/products", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.CreateProduct)
+	api.Put("/products/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UpdateProduct)
+	api.Patch("/products/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UpdateProduct)
+	api.Delete("/products/:id", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.ArchiveProduct)
+	api.Post("/products/:id/archive", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.ArchiveProduct)
+	api.Post("/products/:id/unarchive", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UnarchiveProduct)
+	api.Post("/products/:id/image", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UploadProductImage)
+	api.Get("/products/:id/images", middleware.OptionalAuth(cfg.JWTSecret), productHandler.GetImages)
+	api.Get("/products/:id/recommendations", middleware.OptionalAuth(cfg.JWTSecret), productHandler.GetRecommendations)
+	api.Get("/products/:id/availability", productHandler.GetAvailability)
+	api.Post("/products/:id/images", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UploadImages)
+	api.Put("/products/:id/images/order", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.ReorderImages)
+	api.Patch("/products/:id/images/:imageId", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UpdateImage)
+	api.Delete("/products/:id/images/:imageId", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.DeleteImage)
+	// Product options and variants
+	api.Get("/products/:id/variants", middleware.OptionalAuth(cfg.JWTSecret), productHandler.GetVariants)
+	api.Put("/products/:id/options", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.SetOptions)
+	api.Post("/products/:id/variants", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.CreateVariant)
+	api.Put("/products/:id/variants/:variantId", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UpdateVariant)
+	api.Delete("/products/:id/variants/:variantId", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.DeleteVariant)
+	api.Post("/products/:id/variants/:variantId/image", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), productHandler.UploadVariantImage)
+	// Reviews and ratings
+	api.Get("/products/:id/reviews", reviewHandler.GetProductReviews)
+	api.Post("/products/:id/reviews", middleware.AuthRequired(cfg.JWTSecret), reviewHandler.CreateReview)
+	api.Put("/reviews/:id", middleware.AuthRequired(cfg.JWTSecret), reviewHandler.UpdateReview)
+	api.Delete("/reviews/:id", middleware.AuthRequired(cfg.JWTSecret), reviewHandler.DeleteReview)
+	api.Post("/reviews/:id/helpful", middleware.AuthRequired(cfg.JWTSecret), reviewHandler.VoteHelpful)
+	api.Delete("/reviews/:id/helpful", middleware.AuthRequired(cfg.JWTSecret), reviewHandler.RemoveHelpfulVote)
+	adminReviews := api.Group("/admin/reviews", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminReviews.Get("/", reviewHandler.GetReviews)
+	adminReviews.Put("/:id", reviewHandler.ModerateReview)
+	// Bulk catalogue import and export
+	adminProducts := api.Group("/admin/products", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminProducts.Post("/import", productHandler.ImportProducts)
+	adminProducts.Get("/export", productHandler.ExportProducts)
+	adminProducts.Post("/:id/stock", inventoryHandler.AdjustStock)
+	adminProducts.Get("/:id/stock-movements", inventoryHandler.GetStockMovements)
+	adminProducts.Put("/:id/low-stock-threshold", inventoryHandler.SetLowStockThreshold)
+
+	adminInventory := api.Group("/admin/inventory", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminInventory.Get("/alerts", inventoryHandler.GetLowStockAlerts)
+	adminInventory.Post("/alerts/:id/acknowledge", inventoryHandler.AcknowledgeLowStockAlert)
+	adminInventory.Get("/low-stock", inventoryHandler.GetLowStockDigest)
+	adminInventory.Get("/transfers", inventoryHandler.GetTransfers)
+	adminInventory.Post("/transfers", inventoryHandler.CreateTransfer)
+	adminInventory.Get("/transfers/:id", inventoryHandler.GetTransfer)
+	adminInventory.Post("/transfers/:id/receive", inventoryHandler.ReceiveTransfer)
+	adminInventory.Post("/transfers/:id/cancel", inventoryHandler.CancelTransfer)
+
+	// Location routes
+	api.Get("/locations", locationHandler.GetLocations)
+	adminLocations := api.Group("/admin/locations", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminLocations.Get("/", locationHandler.GetAllLocations)
+	adminLocations.Post("/", locationHandler.CreateLocation)
+	adminLocations.Put("/:id", locationHandler.UpdateLocation)
+	adminLocations.Get("/:id/stock", locationHandler.GetLocationStock)
+
+	// Category routes
+	api.Get("/categories", middleware.OptionalAuth(cfg.JWTSecret), categoryHandler.GetCategories)
+	api.Get("/categories/:id", middleware.OptionalAuth(cfg.JWTSecret), categoryHandler.GetCategory)
+	adminCategories := api.Group("/admin/categories", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminCategories.Post("/", categoryHandler.CreateCategory)
+	adminCategories.Put("/:id", categoryHandler.UpdateCategory)
+	adminCategories.Delete("/:id", categoryHandler.DeleteCategory)
 	// Endpoint to list and create orders
 	api.Get("/orders", middleware.AuthRequired(cfg.JWTSecret), orderHandler.GetUserOrders)
+	api.Get("/orders/lookup", orderHandler.LookupOrder)
 	api.Post("/orders", middleware.AuthRequired(cfg.JWTSecret), orderHandler.CreateOrder)
 	api.Get("/admin/orders", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), orderHandler.GetAllOrders)
 	api.Put("/admin/orders/:id/status", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired(), orderHandler.UpdateOrderStatus)
 
+	// Cart routes
+	cart := api.Group("/cart", middleware.OptionalAuth(cfg.JWTSecret))
+	cart.Get("/", cartHandler.GetCart)
+	cart.Delete("/", cartHandler.ClearCart)
+	cart.Post("/items", cartHandler.AddItem)
+	cart.Put("/items/:productId", cartHandler.UpdateItem)
+	cart.Delete("/items/:productId", cartHandler.RemoveItem)
+	cart.Put("/contact", cartHandler.SetContact)
+	cart.Put("/coupon", cartHandler.ApplyCoupon)
+	cart.Delete("/coupon", cartHandler.RemoveCoupon)
+	cart.Post("/checkout", cartHandler.Checkout)
+
+	// Admin coupon routes
+	adminCoupons := api.Group("/admin/coupons", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminCoupons.Get("/", couponHandler.GetCoupons)
+	adminCoupons.Post("/", couponHandler.CreateCoupon)
+	adminCoupons.Get("/:id", couponHandler.GetCoupon)
+	adminCoupons.Put("/:id", couponHandler.UpdateCoupon)
+	adminCoupons.Delete("/:id", couponHandler.DeleteCoupon)
+
+	// Admin promotion routes
+	adminPromotions := api.Group("/admin/promotions", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminPromotions.Get("/", promotionHandler.GetPromotions)
+	adminPromotions.Post("/", promotionHandler.CreatePromotion)
+	adminPromotions.Get("/:id", promotionHandler.GetPromotion)
+	adminPromotions.Put("/:id", promotionHandler.UpdatePromotion)
+	adminPromotions.Delete("/:id", promotionHandler.DeletePromotion)
+
+	// Wishlist routes
+	wishlist := api.Group("/wishlist", middleware.AuthRequired(cfg.JWTSecret))
+	wishlist.Get("/", wishlistHandler.GetWishlist)
+	wishlist.Post("/", wishlistHandler.AddItem)
+	wishlist.Post("/share", wishlistHandler.Share)
+	wishlist.Delete("/share", wishlistHandler.Unshare)
+	wishlist.Delete("/:productId", wishlistHandler.RemoveItem)
+	wishlist.Post("/:productId/move-to-cart", wishlistHandler.MoveToCart)
+	api.Get("/wishlists/shared/:token", wishlistHandler.GetSharedWishlist)
+
+	// Gift card and store credit routes
+	api.Get("/gift-cards/:code", walletHandler.CheckGiftCard)
+	api.Post("/gift-cards", middleware.AuthRequired(cfg.JWTSecret), walletHandler.PurchaseGiftCard)
+	api.Get("/store-credit", middleware.AuthRequired(cfg.JWTSecret), walletHandler.GetMyStoreCredit)
+
+	// Admin gift card routes
+	adminGiftCards := api.Group("/admin/gift-cards", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminGiftCards.Get("/", walletHandler.GetGiftCards)
+	adminGiftCards.Post("/", walletHandler.IssueGiftCard)
+	adminGiftCards.Delete("/:id", walletHandler.DeactivateGiftCard)
+
+	// Admin report routes
+	adminReports := api.Group("/admin/reports", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminReports.Get("/abandoned-carts", reportHandler.AbandonedCarts)
+
 	// M-Pesa payment simulation routes
-	api.Post("/mpesa/stkpush", middleware.AuthRequired(cfg.JWTSecret), mpesaHandler.InitiateSTKPush)
-	api.Get("/mpesa/transaction/:id", middleware.AuthRequired(cfg.JWTSecret), mpesaHandler.GetTransactionStatus)
+	api.Post("/mpesa/stkpush", middleware.OptionalAuth(cfg.JWTSecret), mpesaHandler.InitiateSTKPush)
+	api.Get("/mpesa/transaction/:id", middleware.OptionalAuth(cfg.JWTSecret), mpesaHandler.GetTransactionStatus)
 	api.Post("/mpesa/webhook", mpesaHandler.Webhook)
+	adminPayments := api.Group("/admin/payments", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
+	adminPayments.Get("/review", mpesaHandler.GetPaymentReviews)
+	adminPayments.Post("/:id/resolve", mpesaHandler.ResolvePaymentReview)
 
 	// Health check
 	api.Get("/health", func(c *fiber.Ctx) error {
@@ -113,6 +296,7 @@ func main() {
 	auth.Post("/register", authHandler.Register)
 	auth.Post("/login", authHandler.Login)
 	auth.Get("/profile", middleware.AuthRequired(cfg.JWTSecret), authHandler.GetProfile)
+	auth.Get("/profile/loyalty", middleware.AuthRequired(cfg.JWTSecret), loyaltyHandler.GetMyPoints)
 
 	// Admin user management routes
 	adminUsers := api.Group("/admin/users", middleware.AuthRequired(cfg.JWTSecret), middleware.AdminRequired())
@@ -120,9 +304,15 @@ func main() {
 	adminUsers.Get(":id", userHandler.GetUserByID)
 	adminUsers.Put(":id", userHandler.UpdateUser)
 	adminUsers.Delete(":id", userHandler.DeleteUser)
+	adminUsers.Get(":id/store-credit", walletHandler.GetUserStoreCredit)
+	adminUsers.Post(":id/store-credit", walletHandler.AdjustStoreCredit)
 
-	// Serve uploaded files
-	app.Static("/uploads", cfg.UploadPath)
+	// Serve uploaded files; object stores are reached through redirects
+	if cfg.StorageDriver == "s3" {
+		app.Get("/uploads/*", handlers.NewUploadHandler(store).Serve)
+	} else {
+		app.Static("/uploads", cfg.UploadPath)
+	}
 
 	// Root route
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +20,37 @@ type Config struct {
 	Port       string
 	UploadPath string
 	Env        string
+
+	UploadBaseURL  string
+	UploadMaxBytes int64
+
+	StorageDriver     string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3PathStyle       bool
+	S3PublicURL       string
+	S3SignedURLTTL    time.Duration
+
+	FrontendURL       string
+	Notifier          string
+	NotifyWebhookURL  string
+	AbandonedCartIdle time.Duration
+	AbandonedCartScan time.Duration
+	ReservationTTL    time.Duration
+	CoPurchaseRefresh time.Duration
+
+	MpesaWebhookSecret string
+
+	LowStockThreshold int
+	LowStockCheck     time.Duration
+	LowStockDigest    time.Duration
+
+	LoyaltyPointsPerKES float64
+	LoyaltyPointValue   float64
+	LoyaltyPointsExpiry time.Duration
 }
 
 func LoadConfig() *Config {
@@ -36,6 +69,37 @@ func LoadConfig() *Config {
 		Port:       getEnv("PORT", "8082"),
 		UploadPath: getEnv("UPLOAD_PATH", "./uploads"),
 		Env:        getEnv("ENV", "development"),
+
+		UploadBaseURL:  getEnv("UPLOAD_BASE_URL", "/uploads"),
+		UploadMaxBytes: int64(getEnvFloat("UPLOAD_MAX_MB", 8) * (1 << 20)),
+
+		StorageDriver:     getEnv("STORAGE_DRIVER", "local"),
+		S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3PathStyle:       getEnv("S3_PATH_STYLE", "false") == "true",
+		S3PublicURL:       getEnv("S3_PUBLIC_URL", ""),
+		S3SignedURLTTL:    getEnvDuration("S3_SIGNED_URL_TTL", 15*time.Minute),
+
+		FrontendURL:       getEnv("FRONTEND_URL", "http://localhost:5173"),
+		Notifier:          getEnv("NOTIFIER", "log"),
+		NotifyWebhookURL:  getEnv("NOTIFY_WEBHOOK_URL", ""),
+		AbandonedCartIdle: getEnvDuration("ABANDONED_CART_IDLE", 2*time.Hour),
+		AbandonedCartScan: getEnvDuration("ABANDONED_CART_SCAN_INTERVAL", 15*time.Minute),
+		ReservationTTL:    getEnvDuration("RESERVATION_TTL", 15*time.Minute),
+		CoPurchaseRefresh: getEnvDuration("RECOMMENDATIONS_REFRESH_INTERVAL", time.Hour),
+
+		MpesaWebhookSecret: getEnv("MPESA_WEBHOOK_SECRET", ""),
+
+		LowStockThreshold: getEnvInt("LOW_STOCK_THRESHOLD", 5),
+		LowStockCheck:     getEnvDuration("LOW_STOCK_CHECK_INTERVAL", 15*time.Minute),
+		LowStockDigest:    getEnvDuration("LOW_STOCK_DIGEST_INTERVAL", 24*time.Hour),
+
+		LoyaltyPointsPerKES: getEnvFloat("LOYALTY_POINTS_PER_KES", 0.01),
+		LoyaltyPointValue:   getEnvFloat("LOYALTY_POINT_VALUE", 1),
+		LoyaltyPointsExpiry: getEnvDuration("LOYALTY_POINTS_EXPIRY", 365*24*time.Hour),
 	}
 
 	// Validate required fields
@@ -61,3 +125,44 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	// Every duration setting is an interval or lifetime, so zero and negative
+	// values are as wrong as unparseable ones
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid duration for %s (%q), using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s (%q), using %g", key, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid whole number for %s (%q), using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
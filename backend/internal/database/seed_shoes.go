@@ -69,7 +69,14 @@ func SeedShoes(db *sql.DB) {
 
 	for _, shoe := range shoes {
 		_, err := db.ExecContext(context.Background(),
-			`INSERT INTO products (name, description, price, image_url, category, stock, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (name) DO NOTHING`,
+			`WITH p AS (
+				INSERT INTO products (name, description, price, image_url, category, category_id, stock, created_at)
+				VALUES ($1, $2, $3, $4, COALESCE((SELECT name FROM categories WHERE slug = LOWER($5)), $5), (SELECT id FROM categories WHERE slug = LOWER($5)), $6, $7)
+				ON CONFLICT (name) DO NOTHING
+				RETURNING id, stock
+			)
+			INSERT INTO stock_movements (product_id, quantity, movement_type, reason)
+			SELECT id, stock, 'restock', 'Opening stock' FROM p WHERE stock <> 0`,
 			shoe.Name, shoe.Description, shoe.Price, shoe.ImageURL, shoe.Category, shoe.Stock, shoe.CreatedAt,
 		)
 		if err != nil {
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// stockNeed is how much of a product, or one of its variants, an order takes.
+type stockNeed struct {
+	productID uuid.UUID
+	variantID uuid.NullUUID
+	quantity  int
+}
+
+// stockKey identifies a product or variant at a location.
+type stockKey struct {
+	locationID uuid.UUID
+	productID  uuid.UUID
+	variantID  uuid.UUID
+}
+
+// allocation is the part of a need taken from one location.
+type allocation struct {
+	need       stockNeed
+	locationID uuid.UUID
+	quantity   int
+}
+
+type fulfillmentLocation struct {
+	id        uuid.UUID
+	city      string
+	latitude  float64
+	longitude float64
+	priority  int
+	distance  float64
+}
+
+// allocateOrder decides which locations a paid order ships from. Locations
+// are ranked by distance from the customer, then by priority. The customer
+// is at the pickup shop for pickup orders, otherwise at the first location
+// whose city appears in the shipping address; when neither is known
+// locations are ranked by priority alone. The nearest location with stock
+// for the whole order fulfils it; when none has, each item is taken from the
+// nearest locations that hold it. The stock rows must already be locked.
+func allocateOrder(tx *sql.Tx, orderID string, needs []stockNeed) ([]allocation, error) {
+	var pickupID uuid.NullUUID
+	var address string
+	err := tx.QueryRow(`SELECT pickup_location_id, shipping_address FROM orders WHERE id = $1`, orderID).Scan(&pickupID, &address)
+	if err != nil {
+		return nil, fiber.NewError(500, "Failed to load order")
+	}
+
+	rows, err := tx.Query(`SELECT id, city, latitude, longitude, priority FROM locations WHERE is_active ORDER BY priority, code`)
+	if err != nil {
+		return nil, fiber.NewError(500, "Failed to load locations")
+	}
+	var locations []fulfillmentLocation
+	for rows.Next() {
+		var l fulfillmentLocation
+		if err := rows.Scan(&l.id, &l.city, &l.latitude, &l.longitude, &l.priority); err != nil {
+			rows.Close()
+			return nil, fiber.NewError(500, "Failed to load locations")
+		}
+		locations = append(locations, l)
+	}
+	rows.Close()
+	rankLocations(locations, pickupID, address)
+
+	productIDs := make([]string, len(needs))
+	for i, n := range needs {
+		productIDs[i] = n.productID.String()
+	}
+	stock := map[stockKey]int{}
+	rows, err = tx.Query(
+		`SELECT location_id, product_id, COALESCE(variant_id, product_id), quantity FROM location_stock
+		WHERE product_id = ANY($1::uuid[]) AND quantity > 0`, pq.Array(productIDs))
+	if err != nil {
+		return nil, fiber.NewError(500, "Failed to load location stock")
+	}
+	for rows.Next() {
+		var k stockKey
+		var quantity int
+		if err := rows.Scan(&k.locationID, &k.productID, &k.variantID, &quantity); err != nil {
+			rows.Close()
+			return nil, fiber.NewError(500, "Failed to load location stock")
+		}
+		stock[k] = quantity
+	}
+	rows.Close()
+
+	key := func(l fulfillmentLocation, n stockNeed) stockKey {
+		k := stockKey{locationID: l.id, productID: n.productID, variantID: n.productID}
+		if n.variantID.Valid {
+			k.variantID = n.variantID.UUID
+		}
+		return k
+	}
+	for _, l := range locations {
+		complete := true
+		for _, n := range needs {
+			if stock[key(l, n)] < n.quantity {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			allocations := make([]allocation, len(needs))
+			for i, n := range needs {
+				allocations[i] = allocation{need: n, locationID: l.id, quantity: n.quantity}
+			}
+			return allocations, nil
+		}
+	}
+
+	var allocations []allocation
+	for _, n := range needs {
+		remaining := n.quantity
+		for _, l := range locations {
+			take := min(remaining, stock[key(l, n)])
+			if take <= 0 {
+				continue
+			}
+			allocations = append(allocations, allocation{need: n, locationID: l.id, quantity: take})
+			remaining -= take
+			if remaining == 0 {
+				break
+			}
+		}
+		if remaining > 0 {
+			return nil, fiber.NewError(409, "Not enough stock at our locations to fulfil this order")
+		}
+	}
+	return allocations, nil
+}
+
+// rankLocations sorts locations nearest the customer first, then by priority.
+func rankLocations(locations []fulfillmentLocation, pickupID uuid.NullUUID, address string) {
+	origin := -1
+	for i, l := range locations {
+		if pickupID.Valid && l.id == pickupID.UUID {
+			origin = i
+			break
+		}
+	}
+	if origin < 0 && !pickupID.Valid {
+		address = strings.ToLower(address)
+		for i, l := range locations {
+			if l.city != "" && strings.Contains(address, strings.ToLower(l.city)) {
+				origin = i
+				break
+			}
+		}
+	}
+	if origin >= 0 {
+		from := locations[origin]
+		for i := range locations {
+			locations[i].distance = haversineKm(from.latitude, from.longitude, locations[i].latitude, locations[i].longitude)
+		}
+	}
+	sort.SliceStable(locations, func(i, j int) bool {
+		if locations[i].distance != locations[j].distance {
+			return locations[i].distance < locations[j].distance
+		}
+		return locations[i].priority < locations[j].priority
+	})
+}
+
+// haversineKm is the great-circle distance between two points in kilometres.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// fulfillOrder takes a paid order's stock from the locations chosen by
+// allocateOrder, records where it ships from and sets its fulfilment
+// location to the one shipping the most units.
+func fulfillOrder(tx *sql.Tx, orderID string, needs []stockNeed) error {
+	allocations, err := allocateOrder(tx, orderID, needs)
+	if err != nil {
+		return err
+	}
+	units := map[uuid.UUID]int{}
+	var primary uuid.UUID
+	for _, a := range allocations {
+		sale := stockMovement{
+			productID: a.need.productID.String(), variantID: a.need.variantID, locationID: a.locationID.String(),
+			quantity: -a.quantity, movementType: "sale", reason: "Order paid", orderID: orderID,
+		}
+		if err := recordStockMovement(tx, sale); err != nil {
+			return fiber.NewError(500, "Failed to update stock")
+		}
+		_, err := tx.Exec(
+			`INSERT INTO order_allocations (order_id, location_id, product_id, variant_id, quantity) VALUES ($1, $2, $3, $4, $5)`,
+			orderID, a.locationID, a.need.productID, a.need.variantID, a.quantity,
+		)
+		if err != nil {
+			return fiber.NewError(500, "Failed to record order allocation")
+		}
+		units[a.locationID] += a.quantity
+		if units[a.locationID] > units[primary] {
+			primary = a.locationID
+		}
+	}
+	if _, err := tx.Exec(`UPDATE orders SET fulfillment_location_id = $1 WHERE id = $2`, primary, orderID); err != nil {
+		return fiber.NewError(500, "Failed to update order")
+	}
+	return nil
+}
+
+// fetchOrderAllocations loads where an order ships from.
+func fetchOrderAllocations(db *sql.DB, orderID string) ([]models.OrderAllocation, error) {
+	rows, err := db.Query(
+		`SELECT a.location_id, l.name, a.product_id, a.variant_id, a.quantity
+		FROM order_allocations a JOIN locations l ON l.id = a.location_id
+		WHERE a.order_id = $1 ORDER BY l.priority, l.code, a.product_id, a.variant_id NULLS FIRST`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var allocations []models.OrderAllocation
+	for rows.Next() {
+		var a models.OrderAllocation
+		if err := rows.Scan(&a.LocationID, &a.LocationName, &a.ProductID, &a.VariantID, &a.Quantity); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"ecommerce-backend/internal/models"
 	"ecommerce-backend/internal/services"
+	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -97,6 +98,11 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
+	// Carry over anything added to the cart before signing in
+	if err := mergeRequestCart(c, h.db, h.jwtSecret, user.ID.String()); err != nil {
+		log.Printf("Failed to merge guest cart for user %s: %v", user.ID, err)
+	}
+
 	// Generate JWT token
 	token, err := services.GenerateJWT(user.ID.String(), user.Role, h.jwtSecret)
 	if err != nil {
@@ -167,6 +173,11 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Carry over anything added to the cart before signing in
+	if err := mergeRequestCart(c, h.db, h.jwtSecret, user.ID.String()); err != nil {
+		log.Printf("Failed to merge guest cart for user %s: %v", user.ID, err)
+	}
+
 	// Generate JWT token
 	token, err := services.GenerateJWT(user.ID.String(), user.Role, h.jwtSecret)
 	if err != nil {
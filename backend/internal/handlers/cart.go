@@ -0,0 +1,717 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"ecommerce-backend/internal/services"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Guest carts are identified by a signed token sent either as this cookie or
+// in the X-Cart-Token header.
+const (
+	cartTokenCookie = "cart_token"
+	cartTokenHeader = "X-Cart-Token"
+)
+
+type CartHandler struct {
+	db             *sql.DB
+	jwtSecret      string
+	reservationTTL time.Duration
+	loyalty        LoyaltyProgram
+}
+
+func NewCartHandler(db *sql.DB, jwtSecret string, reservationTTL time.Duration, loyalty LoyaltyProgram) *CartHandler {
+	return &CartHandler{db: db, jwtSecret: jwtSecret, reservationTTL: reservationTTL, loyalty: loyalty}
+}
+
+// cartLineConflict is the conflict target matching the unique index on cart
+// lines, which treats a missing variant as a value of its own.
+const cartLineConflict = `(cart_id, product_id, (COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid)))`
+
+// variantQuery reads the optional variant_id query parameter.
+func variantQuery(c *fiber.Ctx) (uuid.NullUUID, error) {
+	v := c.Query("variant_id")
+	if v == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		return uuid.NullUUID{}, fiber.NewError(400, "Invalid variant ID")
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, nil
+}
+
+// guestCartID returns the cart ID carried by the request's cart token, or ""
+// when there is no valid token.
+func guestCartID(c *fiber.Ctx, secret string) string {
+	token := c.Get(cartTokenHeader)
+	if token == "" {
+		token = c.Cookies(cartTokenCookie)
+	}
+	if token == "" {
+		return ""
+	}
+	cartID, err := services.ParseCartToken(token, secret)
+	if err != nil {
+		return ""
+	}
+	return cartID
+}
+
+// resolveCart returns the cart for the request: the signed-in user's cart, or
+// the guest cart named by the cart token. When create is true a missing cart
+// is created (and a new guest token issued); otherwise "" is returned.
+func (h *CartHandler) resolveCart(c *fiber.Ctx, create bool) (string, error) {
+	var id string
+	if userID, ok := c.Locals("user_id").(string); ok && userID != "" {
+		err := h.db.QueryRow(
+			`INSERT INTO carts (user_id) VALUES ($1) ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id RETURNING id`,
+			userID,
+		).Scan(&id)
+		return id, err
+	}
+
+	if guestID := guestCartID(c, h.jwtSecret); guestID != "" {
+		err := h.db.QueryRow(`SELECT id FROM carts WHERE id = $1 AND user_id IS NULL`, guestID).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	if !create {
+		return "", nil
+	}
+
+	if err := h.db.QueryRow(`INSERT INTO carts (user_id) VALUES (NULL) RETURNING id`).Scan(&id); err != nil {
+		return "", err
+	}
+	token := services.SignCartToken(id, h.jwtSecret)
+	c.Set(cartTokenHeader, token)
+	c.Cookie(&fiber.Cookie{
+		Name:     cartTokenCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+	return id, nil
+}
+
+// mergeGuestCart moves the items of a guest cart into the user's cart and
+// deletes the guest cart. Quantities of products present in both are added
+// together and capped at stock on hand.
+func mergeGuestCart(db *sql.DB, guestCartID, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM carts WHERE id = $1 AND user_id IS NULL)`, guestCartID).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	var userCartID string
+	err = tx.QueryRow(
+		`INSERT INTO carts (user_id) VALUES ($1) ON CONFLICT (user_id) DO UPDATE SET updated_at = NOW() RETURNING id`,
+		userID,
+	).Scan(&userCartID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO cart_items (cart_id, product_id, variant_id, quantity)
+		SELECT $1, product_id, variant_id, quantity FROM cart_items WHERE cart_id = $2
+		ON CONFLICT `+cartLineConflict+` DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity, updated_at = NOW()`,
+		userCartID, guestCartID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE cart_items ci SET quantity = p.stock FROM products p
+		WHERE ci.product_id = p.id AND ci.variant_id IS NULL AND ci.cart_id = $1 AND ci.quantity > p.stock AND p.stock > 0`,
+		userCartID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE cart_items ci SET quantity = v.stock FROM product_variants v
+		WHERE ci.variant_id = v.id AND ci.cart_id = $1 AND ci.quantity > v.stock AND v.stock > 0`,
+		userCartID,
+	)
+	if err != nil {
+		return err
+	}
+	// Keep reminder history attached so recovery is still attributed
+	if _, err := tx.Exec(`UPDATE cart_reminders SET cart_id = $1 WHERE cart_id = $2`, userCartID, guestCartID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM carts WHERE id = $1`, guestCartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeRequestCart merges the guest cart carried by the request, if any, into
+// the user's cart and clears the cart token cookie.
+func mergeRequestCart(c *fiber.Ctx, db *sql.DB, secret, userID string) error {
+	guestID := guestCartID(c, secret)
+	if guestID == "" {
+		return nil
+	}
+	c.ClearCookie(cartTokenCookie)
+	return mergeGuestCart(db, guestID, userID)
+}
+
+// loadCart reads a cart with every item priced from the current products row
+// and any applied coupon worked out. A coupon that no longer applies is
+// reported in CouponError rather than failing the read.
+func (h *CartHandler) loadCart(cartID string) (models.Cart, error) {
+	var cart models.Cart
+	var userID, email, couponCode sql.NullString
+	err := h.db.QueryRow(`SELECT id, user_id, email, coupon_code, updated_at FROM carts WHERE id = $1`, cartID).
+		Scan(&cart.ID, &userID, &email, &couponCode, &cart.UpdatedAt)
+	if err != nil {
+		return cart, err
+	}
+	if !userID.Valid {
+		cart.GuestToken = services.SignCartToken(cartID, h.jwtSecret)
+	}
+
+	cart.Items = []models.CartItem{}
+	var lines []pricedLine
+	rows, err := h.db.Query(
+		`SELECT ci.id, ci.product_id, ci.variant_id, COALESCE(v.sku, ''), `+orderItemVariantLabel+`, p.name, p.category_id,
+			COALESCE(NULLIF(v.image_url, ''), p.image_url, ''), COALESCE(v.price, p.price),
+			CASE WHEN NOT COALESCE(p.is_active, true) THEN 0 WHEN v.id IS NULL THEN `+availableStockExpr+` ELSE `+variantAvailableStockExpr+` END, ci.quantity
+		FROM cart_items ci JOIN products p ON ci.product_id = p.id LEFT JOIN product_variants v ON v.id = ci.variant_id
+		WHERE ci.cart_id = $1 ORDER BY ci.created_at`, cartID)
+	if err != nil {
+		return cart, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.CartItem
+		var categoryID uuid.NullUUID
+		if err := rows.Scan(&item.ID, &item.ProductID, &item.VariantID, &item.SKU, &item.VariantLabel, &item.Name, &categoryID,
+			&item.ImageURL, &item.UnitPrice, &item.Stock, &item.Quantity); err != nil {
+			return cart, err
+		}
+		item.InStock = item.Stock >= item.Quantity
+		cart.ItemCount += item.Quantity
+		cart.Items = append(cart.Items, item)
+		pl := pricedLine{ProductID: item.ProductID, Name: item.Name, CategoryID: categoryID, Quantity: item.Quantity, UnitPrice: item.UnitPrice}
+		if item.VariantID != nil {
+			pl.VariantID = uuid.NullUUID{UUID: *item.VariantID, Valid: true}
+		}
+		lines = append(lines, pl)
+	}
+	if err := rows.Err(); err != nil {
+		return cart, err
+	}
+
+	cart.Promotions, err = applyPromotions(h.db, lines)
+	if err != nil {
+		return cart, err
+	}
+	if couponCode.Valid && len(lines) > 0 {
+		cart.CouponCode = couponCode.String
+		if _, err := applyCoupon(h.db, couponCode.String, lines, customerRef{UserID: userID.String, Email: email.String}, false); err != nil {
+			cart.CouponError = errorMessage(err)
+		}
+	}
+
+	for i, pl := range lines {
+		item := &cart.Items[i]
+		item.Discount = pl.Discount
+		item.LineTotal = roundMoney(pl.total() - pl.Discount)
+		cart.Subtotal += pl.total()
+		cart.Discount += pl.Discount
+	}
+	cart.Subtotal = roundMoney(cart.Subtotal)
+	cart.Discount = roundMoney(cart.Discount)
+	cart.Total = roundMoney(cart.Subtotal - cart.Discount)
+	return cart, nil
+}
+
+// cartPricedLines returns the cart's items priced from the current catalogue.
+func cartPricedLines(q querier, cartID string) ([]pricedLine, error) {
+	rows, err := q.Query(
+		`SELECT ci.product_id, ci.variant_id, p.name, p.category_id, COALESCE(v.price, p.price), ci.quantity
+		FROM cart_items ci JOIN products p ON ci.product_id = p.id LEFT JOIN product_variants v ON v.id = ci.variant_id
+		WHERE ci.cart_id = $1 ORDER BY ci.created_at`, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lines []pricedLine
+	for rows.Next() {
+		var pl pricedLine
+		if err := rows.Scan(&pl.ProductID, &pl.VariantID, &pl.Name, &pl.CategoryID, &pl.UnitPrice, &pl.Quantity); err != nil {
+			return nil, err
+		}
+		lines = append(lines, pl)
+	}
+	return lines, rows.Err()
+}
+
+// setItemQuantity validates quantity against available stock and stores it for
+// the product (or variant) in the cart. When add is true the quantity is
+// added to any existing line instead of replacing it.
+func (h *CartHandler) setItemQuantity(cartID string, productID uuid.UUID, variantID uuid.NullUUID, quantity int, add bool) error {
+	if quantity <= 0 {
+		return fiber.NewError(400, "Quantity must be greater than zero")
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return fiber.NewError(500, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var name string
+	var stock int
+	var hasVariants, active bool
+	err = tx.QueryRow(`SELECT p.name, `+availableStockExpr+`, `+hasVariantsExpr+`, COALESCE(p.is_active, true) FROM products p WHERE p.id = $1`, productID).
+		Scan(&name, &stock, &hasVariants, &active)
+	if err == sql.ErrNoRows {
+		return fiber.NewError(404, "Product not found")
+	}
+	if err != nil {
+		return fiber.NewError(500, "Failed to load product")
+	}
+	if !active {
+		return fiber.NewError(409, name+" is no longer available")
+	}
+	if variantID.Valid {
+		var label string
+		err = tx.QueryRow(
+			`SELECT `+variantLabelExpr+`, `+variantAvailableStockExpr+` FROM product_variants v WHERE v.id = $1 AND v.product_id = $2 AND v.is_active`,
+			variantID.UUID, productID,
+		).Scan(&label, &stock)
+		if err == sql.ErrNoRows {
+			return fiber.NewError(404, "Variant not found")
+		}
+		if err != nil {
+			return fiber.NewError(500, "Failed to load product variant")
+		}
+		name = variantName(name, label)
+	} else if hasVariants {
+		return fiber.NewError(400, "Choose an option for "+name)
+	}
+
+	if add {
+		var existing int
+		err = tx.QueryRow(
+			`SELECT quantity FROM cart_items WHERE cart_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM $3 FOR UPDATE`,
+			cartID, productID, variantID,
+		).Scan(&existing)
+		if err != nil && err != sql.ErrNoRows {
+			return fiber.NewError(500, "Failed to load cart item")
+		}
+		quantity += existing
+	}
+	if quantity > stock {
+		return fiber.NewError(409, "Insufficient stock for "+name)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO cart_items (cart_id, product_id, variant_id, quantity) VALUES ($1, $2, $3, $4)
+		ON CONFLICT `+cartLineConflict+` DO UPDATE SET quantity = EXCLUDED.quantity, updated_at = NOW()`,
+		cartID, productID, variantID, quantity,
+	)
+	if err != nil {
+		return fiber.NewError(500, "Failed to save cart item")
+	}
+	if _, err := tx.Exec(`UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID); err != nil {
+		return fiber.NewError(500, "Failed to update cart")
+	}
+	if err := tx.Commit(); err != nil {
+		return fiber.NewError(500, "Failed to save cart item")
+	}
+	return nil
+}
+
+// cartResponse writes the cart with the given status.
+func (h *CartHandler) cartResponse(c *fiber.Ctx, status int, cartID string) error {
+	cart, err := h.loadCart(cartID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	return c.Status(status).JSON(cart)
+}
+
+// @Summary Get the current cart
+// @Description Returns the signed-in user's cart, or the guest cart named by the X-Cart-Token header or cart_token cookie.
+// @Tags Cart
+// @Produce json
+// @Success 200 {object} models.Cart
+// @Router /api/cart [get]
+func (h *CartHandler) GetCart(c *fiber.Ctx) error {
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.Status(200).JSON(models.Cart{Items: []models.CartItem{}})
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Add an item to the cart
+// @Description Guests without a cart get a new one; its token is returned in the X-Cart-Token header and the cart_token cookie.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param item body models.AddCartItemRequest true "Cart item"
+// @Success 200 {object} models.Cart
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/cart/items [post]
+func (h *CartHandler) AddItem(c *fiber.Ctx) error {
+	var req models.AddCartItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.ProductID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Product ID is required"})
+	}
+	cartID, err := h.resolveCart(c, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	var variantID uuid.NullUUID
+	if req.VariantID != nil {
+		variantID = uuid.NullUUID{UUID: *req.VariantID, Valid: true}
+	}
+	if err := h.setItemQuantity(cartID, req.ProductID, variantID, req.Quantity, true); err != nil {
+		return errorResponse(c, err)
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Update the quantity of a cart item
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param productId path string true "Product ID"
+// @Param variant_id query string false "Variant ID"
+// @Param item body models.UpdateCartItemRequest true "New quantity"
+// @Success 200 {object} models.Cart
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/cart/items/{productId} [put]
+func (h *CartHandler) UpdateItem(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	variantID, err := variantQuery(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	var req models.UpdateCartItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	cartID, err := h.resolveCart(c, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if err := h.setItemQuantity(cartID, productID, variantID, req.Quantity, false); err != nil {
+		return errorResponse(c, err)
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Remove an item from the cart
+// @Tags Cart
+// @Produce json
+// @Param productId path string true "Product ID"
+// @Param variant_id query string false "Variant ID"
+// @Success 200 {object} models.Cart
+// @Failure 404 {object} map[string]string
+// @Router /api/cart/items/{productId} [delete]
+func (h *CartHandler) RemoveItem(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	variantID, err := variantQuery(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not in cart"})
+	}
+	res, err := h.db.Exec(`DELETE FROM cart_items WHERE cart_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM $3`, cartID, productID, variantID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove cart item"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Item not in cart"})
+	}
+	h.db.Exec(`UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Remove all items from the cart
+// @Tags Cart
+// @Success 204 {object} nil
+// @Router /api/cart [delete]
+func (h *CartHandler) ClearCart(c *fiber.Ctx) error {
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.SendStatus(204)
+	}
+	if _, err := h.db.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to clear cart"})
+	}
+	h.db.Exec(`UPDATE carts SET updated_at = NOW() WHERE id = $1`, cartID)
+	return c.SendStatus(204)
+}
+
+// @Summary Set contact details for a guest cart
+// @Description Lets guests leave an email or phone number so they can be reminded about an abandoned cart.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param contact body models.CartContactRequest true "Contact details"
+// @Success 200 {object} models.Cart
+// @Failure 400 {object} map[string]string
+// @Router /api/cart/contact [put]
+func (h *CartHandler) SetContact(c *fiber.Ctx) error {
+	var req models.CartContactRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" && req.PhoneNumber == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Email or phone number is required"})
+	}
+	if req.Email != "" && !strings.Contains(req.Email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid email format"})
+	}
+	cartID, err := h.resolveCart(c, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	_, err = h.db.Exec(
+		`UPDATE carts SET email = NULLIF($1, ''), phone_number = NULLIF($2, '') WHERE id = $3`,
+		req.Email, req.PhoneNumber, cartID,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save contact details"})
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Apply a coupon to the cart
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param coupon body models.ApplyCouponRequest true "Coupon code"
+// @Success 200 {object} models.Cart
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/cart/coupon [put]
+func (h *CartHandler) ApplyCoupon(c *fiber.Ctx) error {
+	var req models.ApplyCouponRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	code := normalizeCouponCode(req.Code)
+	if code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Coupon code is required"})
+	}
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+
+	// Validate against the cart as it stands before saving the code
+	var userID, email sql.NullString
+	if err := h.db.QueryRow(`SELECT user_id, email FROM carts WHERE id = $1`, cartID).Scan(&userID, &email); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	lines, err := cartPricedLines(h.db, cartID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if len(lines) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+	if _, err := applyPromotions(h.db, lines); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to apply promotions"})
+	}
+	if _, err := applyCoupon(h.db, code, lines, customerRef{UserID: userID.String, Email: email.String}, false); err != nil {
+		return errorResponse(c, err)
+	}
+
+	if _, err := h.db.Exec(`UPDATE carts SET coupon_code = $1, updated_at = NOW() WHERE id = $2`, code, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to apply coupon"})
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Remove the coupon from the cart
+// @Tags Cart
+// @Produce json
+// @Success 200 {object} models.Cart
+// @Router /api/cart/coupon [delete]
+func (h *CartHandler) RemoveCoupon(c *fiber.Ctx) error {
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.Status(200).JSON(models.Cart{Items: []models.CartItem{}})
+	}
+	if _, err := h.db.Exec(`UPDATE carts SET coupon_code = NULL, updated_at = NOW() WHERE id = $1`, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove coupon"})
+	}
+	return h.cartResponse(c, 200, cartID)
+}
+
+// @Summary Check out the cart
+// @Description Turns the cart into a pending order priced from the current catalogue and empties the cart. Guests must supply an email and phone number.
+// @Tags Cart
+// @Accept json
+// @Produce json
+// @Param checkout body models.CheckoutRequest true "Shipping and contact details"
+// @Success 201 {object} models.Order
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/cart/checkout [post]
+func (h *CartHandler) Checkout(c *fiber.Ctx) error {
+	var req models.CheckoutRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.ShippingAddress == "" && req.PickupLocationID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Shipping address is required"})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	guestEmail := ""
+	if userID == "" {
+		guestEmail = strings.TrimSpace(req.Email)
+		if !strings.Contains(guestEmail, "@") {
+			return c.Status(400).JSON(fiber.Map{"error": "A valid email is required for guest checkout"})
+		}
+		if req.PhoneNumber == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Phone number is required for guest checkout"})
+		}
+	}
+
+	cartID, err := h.resolveCart(c, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	if cartID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+	if guestEmail != "" {
+		// Remember the contact even if checkout fails so the cart can be recovered
+		h.db.Exec(`UPDATE carts SET email = $1, phone_number = $2 WHERE id = $3`, guestEmail, req.PhoneNumber, cartID)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Lock the cart so a concurrent checkout of the same cart waits for this one
+	if _, err := tx.Exec(`SELECT id FROM carts WHERE id = $1 FOR UPDATE`, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock cart"})
+	}
+	rows, err := tx.Query(`SELECT product_id, variant_id, quantity FROM cart_items WHERE cart_id = $1 ORDER BY created_at`, cartID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	var lines []orderLine
+	for rows.Next() {
+		var line orderLine
+		if err := rows.Scan(&line.ProductID, &line.VariantID, &line.Quantity); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if len(lines) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Cart is empty"})
+	}
+
+	couponCode := normalizeCouponCode(req.CouponCode)
+	if couponCode == "" {
+		tx.QueryRow(`SELECT COALESCE(coupon_code, '') FROM carts WHERE id = $1`, cartID).Scan(&couponCode)
+	}
+
+	orderID, err := placeOrder(tx, orderInput{
+		UserID:           userID,
+		GuestEmail:       guestEmail,
+		Lines:            lines,
+		ShippingAddress:  req.ShippingAddress,
+		PickupLocationID: nullUUID(req.PickupLocationID),
+		PhoneNumber:      req.PhoneNumber,
+		CouponCode:       couponCode,
+		GiftCardCode:     req.GiftCardCode,
+		UseStoreCredit:   req.UseStoreCredit,
+		LoyaltyPoints:    req.LoyaltyPoints,
+		Loyalty:          h.loyalty,
+		ReservationTTL:   h.reservationTTL,
+	})
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to clear cart"})
+	}
+	if _, err := tx.Exec(`UPDATE carts SET coupon_code = NULL WHERE id = $1`, cartID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to clear cart"})
+	}
+	// Attribute the order to the latest outstanding abandoned cart reminder
+	_, err = tx.Exec(
+		`UPDATE cart_reminders SET recovered_order_id = $1, recovered_at = NOW()
+		WHERE id = (SELECT id FROM cart_reminders WHERE cart_id = $2 AND recovered_order_id IS NULL AND sent_at > NOW() - INTERVAL '7 days' ORDER BY sent_at DESC LIMIT 1)`,
+		orderID, cartID,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record cart recovery"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to commit order"})
+	}
+
+	order, err := fetchOrder(h.db, orderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch created order"})
+	}
+	return c.Status(201).JSON(order)
+}
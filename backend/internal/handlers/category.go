@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type CategoryHandler struct {
+	db *sql.DB
+}
+
+func NewCategoryHandler(db *sql.DB) *CategoryHandler {
+	return &CategoryHandler{db: db}
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a name into a URL-friendly slug, matching the conversion the
+// categories migration applied to existing data.
+func slugify(s string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(strings.TrimSpace(s)), "-"), "-")
+}
+
+// categorySubtreeSQL selects the IDs of the category whose ID or slug is %[1]d
+// and all of its descendants. Use it with fmt.Sprintf and a placeholder
+// number.
+const categorySubtreeSQL = `WITH RECURSIVE tree AS (
+		SELECT id FROM categories WHERE id::text = $%[1]d OR slug = LOWER($%[1]d)
+		UNION ALL
+		SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+	) SELECT id FROM tree`
+
+// categoryArraySubtreeSQL expands the text array of category IDs in the
+// column or expression %[1]s with the IDs of all their descendants, as a
+// text array. The original entries are kept even when the category is gone,
+// so a restricted discount never turns into one covering everything. Use it
+// with fmt.Sprintf.
+const categoryArraySubtreeSQL = `ARRAY(WITH RECURSIVE tree AS (
+		SELECT id FROM categories WHERE id::text = ANY(%[1]s)
+		UNION ALL
+		SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+	) SELECT id::text FROM tree UNION SELECT unnest(%[1]s))`
+
+// categoryColumns selects a categories row aliased c. Scan the result with
+// scanCategory.
+const categoryColumns = `c.id, c.parent_id, c.name, c.slug, COALESCE(c.description, ''), COALESCE(c.image_url, ''), c.position, c.is_active,
+	(SELECT COUNT(*) FROM products p WHERE p.category_id = c.id), c.created_at, c.updated_at`
+
+func scanCategory(row interface{ Scan(...interface{}) error }) (models.Category, error) {
+	var cat models.Category
+	err := row.Scan(&cat.ID, &cat.ParentID, &cat.Name, &cat.Slug, &cat.Description, &cat.ImageURL, &cat.Position, &cat.IsActive,
+		&cat.ProductCount, &cat.CreatedAt, &cat.UpdatedAt)
+	return cat, err
+}
+
+// buildCategoryTree nests categories under their parents, keeping the order
+// they were given in. Categories whose parent is not in the list become
+// roots.
+func buildCategoryTree(list []models.Category) []models.Category {
+	byParent := map[uuid.UUID][]models.Category{}
+	present := map[uuid.UUID]bool{}
+	for _, cat := range list {
+		present[cat.ID] = true
+	}
+	var roots []models.Category
+	for _, cat := range list {
+		if cat.ParentID != nil && present[*cat.ParentID] {
+			byParent[*cat.ParentID] = append(byParent[*cat.ParentID], cat)
+		} else {
+			roots = append(roots, cat)
+		}
+	}
+	var attach func(cats []models.Category) []models.Category
+	attach = func(cats []models.Category) []models.Category {
+		for i := range cats {
+			cats[i].Children = attach(byParent[cats[i].ID])
+		}
+		return cats
+	}
+	if roots == nil {
+		return []models.Category{}
+	}
+	return attach(roots)
+}
+
+// resolveCategoryRefs resolves category IDs, slugs or names to the IDs they
+// name, without duplicates. Unknown categories are a client error.
+func resolveCategoryRefs(q querier, refs []string) ([]string, error) {
+	ids := []string{}
+	for _, ref := range refs {
+		id, _, err := resolveCategory(q, ref, false)
+		if err != nil {
+			return nil, err
+		}
+		if id.Valid && !containsFold(ids, id.UUID.String()) {
+			ids = append(ids, id.UUID.String())
+		}
+	}
+	return ids, nil
+}
+
+// resolveCategory finds a category by ID, slug or name. With create set a
+// missing category is added at the top level, so free-text categories from
+// older clients still end up in the tree. A blank ref resolves to nothing.
+func resolveCategory(q querier, ref string, create bool) (uuid.NullUUID, string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return uuid.NullUUID{}, "", nil
+	}
+	var id uuid.UUID
+	var name string
+	err := q.QueryRow(
+		`SELECT id, name FROM categories WHERE id::text = $1 OR slug = $2 OR LOWER(name) = LOWER($1) ORDER BY id::text = $1 DESC, slug = $2 DESC LIMIT 1`,
+		ref, slugify(ref),
+	).Scan(&id, &name)
+	if err == sql.ErrNoRows && create {
+		if _, parseErr := uuid.Parse(ref); parseErr == nil {
+			return uuid.NullUUID{}, "", fiber.NewError(400, "Category not found")
+		}
+		err = q.QueryRow(`INSERT INTO categories (name, slug) VALUES ($1, $2) RETURNING id, name`, ref, slugify(ref)).Scan(&id, &name)
+	}
+	if err == sql.ErrNoRows {
+		return uuid.NullUUID{}, "", fiber.NewError(400, "Category not found")
+	}
+	if err != nil {
+		return uuid.NullUUID{}, "", fiber.NewError(500, "Failed to load category")
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, name, nil
+}
+
+// validateCategoryRequest normalises and checks a create or update request.
+// For updates id is the category being changed, which may not be moved under
+// itself or one of its descendants.
+func validateCategoryRequest(q querier, id string, req *models.CategoryRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fiber.NewError(400, "Name is required")
+	}
+	if req.Slug == "" {
+		req.Slug = req.Name
+	}
+	req.Slug = slugify(req.Slug)
+	if req.Slug == "" {
+		return fiber.NewError(400, "Slug must contain letters or digits")
+	}
+	if req.ParentID == nil {
+		return nil
+	}
+
+	var exists bool
+	if err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, *req.ParentID).Scan(&exists); err != nil {
+		return fiber.NewError(500, "Failed to load parent category")
+	}
+	if !exists {
+		return fiber.NewError(400, "Parent category not found")
+	}
+	if id == "" {
+		return nil
+	}
+	var cycle bool
+	err := q.QueryRow(`SELECT $2 IN (`+fmt.Sprintf(categorySubtreeSQL, 1)+`)`, id, *req.ParentID).Scan(&cycle)
+	if err != nil {
+		return fiber.NewError(500, "Failed to check category tree")
+	}
+	if cycle {
+		return fiber.NewError(400, "A category cannot be moved under itself or one of its subcategories")
+	}
+	return nil
+}
+
+// saveCategoryError maps a failed category insert or update to a response.
+func saveCategoryError(c *fiber.Ctx, err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "Slug already exists"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to save category"})
+}
+
+// @Summary List categories
+// @Description Returns the category tree, or a flat list with flat=true. Admins may pass include_inactive=true.
+// @Tags Categories
+// @Produce json
+// @Param flat query bool false "Return a flat list"
+// @Param include_inactive query bool false "Include inactive categories (admin)"
+// @Success 200 {array} models.Category
+// @Router /api/categories [get]
+func (h *CategoryHandler) GetCategories(c *fiber.Ctx) error {
+	includeInactive := c.QueryBool("include_inactive") && c.Locals("role") == "admin"
+	rows, err := h.db.Query(`SELECT `+categoryColumns+` FROM categories c WHERE c.is_active OR $1 ORDER BY c.position, c.name`, includeInactive)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch categories"})
+	}
+	defer rows.Close()
+	categories := []models.Category{}
+	for rows.Next() {
+		if cat, err := scanCategory(rows); err == nil {
+			categories = append(categories, cat)
+		}
+	}
+	if c.QueryBool("flat") {
+		return c.Status(200).JSON(categories)
+	}
+	return c.Status(200).JSON(buildCategoryTree(categories))
+}
+
+// @Summary Get a category
+// @Description Looks a category up by ID or slug and returns it with its direct subcategories. Inactive categories are only found by admins, who may pass include_inactive=true to list inactive subcategories too.
+// @Tags Categories
+// @Produce json
+// @Param id path string true "Category ID or slug"
+// @Param include_inactive query bool false "Include inactive subcategories (admin)"
+// @Success 200 {object} models.Category
+// @Failure 404 {object} map[string]string
+// @Router /api/categories/{id} [get]
+func (h *CategoryHandler) GetCategory(c *fiber.Ctx) error {
+	ref := c.Params("id")
+	isAdmin := c.Locals("role") == "admin"
+	cat, err := scanCategory(h.db.QueryRow(
+		`SELECT `+categoryColumns+` FROM categories c WHERE (c.id::text = $1 OR c.slug = LOWER($1)) AND (c.is_active OR $2)`, ref, isAdmin))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+	includeInactive := c.QueryBool("include_inactive") && isAdmin
+	rows, err := h.db.Query(
+		`SELECT `+categoryColumns+` FROM categories c WHERE c.parent_id = $1 AND (c.is_active OR $2) ORDER BY c.position, c.name`, cat.ID, includeInactive)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch subcategories"})
+	}
+	defer rows.Close()
+	cat.Children = []models.Category{}
+	for rows.Next() {
+		if child, err := scanCategory(rows); err == nil {
+			cat.Children = append(cat.Children, child)
+		}
+	}
+	return c.Status(200).JSON(cat)
+}
+
+// @Summary Create a category (admin)
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param category body models.CategoryRequest true "Category"
+// @Success 201 {object} models.Category
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/admin/categories [post]
+func (h *CategoryHandler) CreateCategory(c *fiber.Ctx) error {
+	var req models.CategoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateCategoryRequest(h.db, "", &req); err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	cat, err := scanCategory(h.db.QueryRow(
+		`INSERT INTO categories AS c (parent_id, name, slug, description, image_url, position, is_active)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7) RETURNING `+categoryColumns,
+		req.ParentID, req.Name, req.Slug, req.Description, req.ImageURL, req.Position, isActive,
+	))
+	if err != nil {
+		return saveCategoryError(c, err)
+	}
+	return c.Status(201).JSON(cat)
+}
+
+// @Summary Update a category (admin)
+// @Description Renaming a category also renames it on its products.
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param category body models.CategoryRequest true "Category"
+// @Success 200 {object} models.Category
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/admin/categories/{id} [put]
+func (h *CategoryHandler) UpdateCategory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category ID"})
+	}
+	var req models.CategoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Lock the tree so concurrent moves cannot create a cycle
+	if _, err := tx.Exec(`LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock categories"})
+	}
+	if err := validateCategoryRequest(tx, id, &req); err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	cat, err := scanCategory(tx.QueryRow(
+		`UPDATE categories c SET parent_id = $1, name = $2, slug = $3, description = NULLIF($4, ''), image_url = NULLIF($5, ''),
+			position = $6, is_active = $7, updated_at = NOW()
+		WHERE c.id = $8 RETURNING `+categoryColumns,
+		req.ParentID, req.Name, req.Slug, req.Description, req.ImageURL, req.Position, isActive, id,
+	))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+	if err != nil {
+		return saveCategoryError(c, err)
+	}
+	if _, err := tx.Exec(`UPDATE products SET category = $1, updated_at = NOW() WHERE category_id = $2 AND category IS DISTINCT FROM $1`, cat.Name, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update products"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save category"})
+	}
+	return c.Status(200).JSON(cat)
+}
+
+// @Summary Delete a category (admin)
+// @Description Only categories without subcategories or products can be deleted.
+// @Tags Categories
+// @Param id path string true "Category ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/admin/categories/{id} [delete]
+func (h *CategoryHandler) DeleteCategory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid category ID"})
+	}
+	var inUse bool
+	err := h.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = $1) OR EXISTS (SELECT 1 FROM products WHERE category_id = $1)`, id,
+	).Scan(&inUse)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete category"})
+	}
+	if inUse {
+		return c.Status(409).JSON(fiber.Map{"error": "Category still has subcategories or products"})
+	}
+	res, err := h.db.Exec(`DELETE FROM categories WHERE id = $1`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete category"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+	return c.SendStatus(204)
+}
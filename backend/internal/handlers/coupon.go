@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type CouponHandler struct {
+	db *sql.DB
+}
+
+func NewCouponHandler(db *sql.DB) *CouponHandler {
+	return &CouponHandler{db: db}
+}
+
+// appliedCoupon is the outcome of applying a coupon to a set of lines.
+type appliedCoupon struct {
+	ID       string
+	Code     string
+	Discount float64
+}
+
+// normalizeCouponCode makes codes case-insensitive.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// applyCoupon validates code against lines and adds the discount to the
+// Discount of each eligible line. With lock set the coupon row is locked so
+// that usage limits hold under concurrent checkouts; q must then be a
+// transaction. Validation failures are returned as *fiber.Error.
+func applyCoupon(q querier, code string, lines []pricedLine, who customerRef, lock bool) (*appliedCoupon, error) {
+	query := `SELECT id, code, discount_type, discount_value, min_order_amount, product_ids, ` + fmt.Sprintf(categoryArraySubtreeSQL, "categories") + `,
+		starts_at, ends_at, usage_limit, per_user_limit, times_used
+		FROM coupons WHERE code = $1 AND is_active = true`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	var (
+		couponID, couponCode, discountType string
+		value, minOrder                    float64
+		productIDs, categories             []string
+		startsAt, endsAt                   sql.NullTime
+		usageLimit, perUserLimit           sql.NullInt64
+		timesUsed                          int
+	)
+	err := q.QueryRow(query, normalizeCouponCode(code)).Scan(
+		&couponID, &couponCode, &discountType, &value, &minOrder, pq.Array(&productIDs), pq.Array(&categories),
+		&startsAt, &endsAt, &usageLimit, &perUserLimit, &timesUsed,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fiber.NewError(404, "Coupon not found")
+	}
+	if err != nil {
+		return nil, fiber.NewError(500, "Failed to load coupon")
+	}
+
+	now := time.Now()
+	if startsAt.Valid && now.Before(startsAt.Time) {
+		return nil, fiber.NewError(400, "Coupon is not valid yet")
+	}
+	if endsAt.Valid && !now.Before(endsAt.Time) {
+		return nil, fiber.NewError(400, "Coupon has expired")
+	}
+	if usageLimit.Valid && int64(timesUsed) >= usageLimit.Int64 {
+		return nil, fiber.NewError(409, "Coupon usage limit reached")
+	}
+	if perUserLimit.Valid && (who.UserID != "" || who.Email != "") {
+		var used int64
+		err := q.QueryRow(
+			`SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = $1 AND (user_id::text = $2 OR LOWER(guest_email) = LOWER($3))`,
+			couponID, who.UserID, who.Email,
+		).Scan(&used)
+		if err != nil {
+			return nil, fiber.NewError(500, "Failed to check coupon usage")
+		}
+		if used >= perUserLimit.Int64 {
+			return nil, fiber.NewError(409, "You have already used this coupon")
+		}
+	}
+
+	var subtotal float64
+	for _, l := range lines {
+		subtotal += l.remaining()
+	}
+	if subtotal < minOrder {
+		return nil, fiber.NewError(400, fmt.Sprintf("Order must be at least KES %.2f to use this coupon", minOrder))
+	}
+
+	// Work out which lines the coupon covers
+	restricted := len(productIDs) > 0 || len(categories) > 0
+	eligible := make([]int, 0, len(lines))
+	var eligibleTotal float64
+	for i, l := range lines {
+		if restricted && !containsFold(productIDs, l.ProductID.String()) && !l.inCategories(categories) {
+			continue
+		}
+		if base := l.remaining(); base > 0 {
+			eligible = append(eligible, i)
+			eligibleTotal += base
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, fiber.NewError(400, "Coupon does not apply to any items")
+	}
+
+	discount := roundMoney(eligibleTotal * value / 100)
+	if discountType == "fixed" {
+		discount = math.Min(value, eligibleTotal)
+	}
+
+	// Spread the discount over eligible lines in proportion to their value,
+	// giving any rounding remainder to the last one
+	remaining := discount
+	for n, i := range eligible {
+		share := remaining
+		if n < len(eligible)-1 {
+			share = roundMoney(discount * lines[i].remaining() / eligibleTotal)
+		}
+		lines[i].Discount = roundMoney(lines[i].Discount + share)
+		remaining -= share
+	}
+
+	return &appliedCoupon{ID: couponID, Code: couponCode, Discount: discount}, nil
+}
+
+// redeemCoupon records that an order used a coupon. It must run in the same
+// transaction that locked the coupon in applyCoupon.
+func redeemCoupon(tx *sql.Tx, coupon *appliedCoupon, orderID string, who customerRef) error {
+	var userID, email interface{}
+	if who.UserID != "" {
+		userID = who.UserID
+	} else if who.Email != "" {
+		email = who.Email
+	}
+	_, err := tx.Exec(
+		`INSERT INTO coupon_redemptions (coupon_id, order_id, user_id, guest_email, discount_amount) VALUES ($1, $2, $3, $4, $5)`,
+		coupon.ID, orderID, userID, email, coupon.Discount,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE coupons SET times_used = times_used + 1, updated_at = NOW() WHERE id = $1`, coupon.ID)
+	return err
+}
+
+// releaseCouponRedemption gives a cancelled order's coupon use back.
+func releaseCouponRedemption(tx *sql.Tx, orderID string) error {
+	_, err := tx.Exec(
+		`WITH released AS (DELETE FROM coupon_redemptions WHERE order_id = $1 RETURNING coupon_id)
+		UPDATE coupons SET times_used = times_used - 1, updated_at = NOW() WHERE id IN (SELECT coupon_id FROM released)`,
+		orderID,
+	)
+	return err
+}
+
+const couponColumns = `id, code, COALESCE(description, ''), discount_type, discount_value, min_order_amount, product_ids, categories,
+	starts_at, ends_at, usage_limit, per_user_limit, times_used, is_active, created_at, updated_at`
+
+func scanCoupon(row interface{ Scan(...interface{}) error }) (models.Coupon, error) {
+	var cp models.Coupon
+	var productIDs []string
+	err := row.Scan(&cp.ID, &cp.Code, &cp.Description, &cp.DiscountType, &cp.DiscountValue, &cp.MinOrderAmount,
+		pq.Array(&productIDs), pq.Array(&cp.Categories), &cp.StartsAt, &cp.EndsAt, &cp.UsageLimit, &cp.PerUserLimit,
+		&cp.TimesUsed, &cp.IsActive, &cp.CreatedAt, &cp.UpdatedAt)
+	if err != nil {
+		return cp, err
+	}
+	cp.ProductIDs = make([]uuid.UUID, 0, len(productIDs))
+	for _, id := range productIDs {
+		if pid, err := uuid.Parse(id); err == nil {
+			cp.ProductIDs = append(cp.ProductIDs, pid)
+		}
+	}
+	if cp.Categories == nil {
+		cp.Categories = []string{}
+	}
+	return cp, nil
+}
+
+// validateCouponRequest checks a create/update request and normalises its code.
+func validateCouponRequest(req *models.CouponRequest) error {
+	req.Code = normalizeCouponCode(req.Code)
+	if req.Code == "" {
+		return fiber.NewError(400, "Code is required")
+	}
+	if req.DiscountType != "percentage" && req.DiscountType != "fixed" {
+		return fiber.NewError(400, "Discount type must be percentage or fixed")
+	}
+	if req.DiscountValue <= 0 || (req.DiscountType == "percentage" && req.DiscountValue > 100) {
+		return fiber.NewError(400, "Discount value must be positive and at most 100 for percentages")
+	}
+	if req.MinOrderAmount < 0 {
+		return fiber.NewError(400, "Minimum order amount cannot be negative")
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return fiber.NewError(400, "End time must be after start time")
+	}
+	if (req.UsageLimit != nil && *req.UsageLimit <= 0) || (req.PerUserLimit != nil && *req.PerUserLimit <= 0) {
+		return fiber.NewError(400, "Usage limits must be positive")
+	}
+	if req.ProductIDs == nil {
+		req.ProductIDs = []uuid.UUID{}
+	}
+	if req.Categories == nil {
+		req.Categories = []string{}
+	}
+	return nil
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id.String()
+	}
+	return out
+}
+
+// @Summary List coupons (admin)
+// @Tags Coupons
+// @Produce json
+// @Success 200 {array} models.Coupon
+// @Security BearerAuth
+// @Router /api/admin/coupons [get]
+func (h *CouponHandler) GetCoupons(c *fiber.Ctx) error {
+	rows, err := h.db.Query(`SELECT ` + couponColumns + ` FROM coupons ORDER BY created_at DESC`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch coupons"})
+	}
+	defer rows.Close()
+	coupons := []models.Coupon{}
+	for rows.Next() {
+		cp, err := scanCoupon(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan coupon"})
+		}
+		coupons = append(coupons, cp)
+	}
+	return c.Status(200).JSON(coupons)
+}
+
+// @Summary Get a coupon (admin)
+// @Tags Coupons
+// @Produce json
+// @Param id path string true "Coupon ID"
+// @Success 200 {object} models.Coupon
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/coupons/{id} [get]
+func (h *CouponHandler) GetCoupon(c *fiber.Ctx) error {
+	cp, err := scanCoupon(h.db.QueryRow(`SELECT `+couponColumns+` FROM coupons WHERE id = $1`, c.Params("id")))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+	return c.Status(200).JSON(cp)
+}
+
+// @Summary Create a coupon (admin)
+// @Description categories take category IDs, slugs or names; they are stored as IDs and also cover subcategories.
+// @Tags Coupons
+// @Accept json
+// @Produce json
+// @Param coupon body models.CouponRequest true "Coupon data"
+// @Success 201 {object} models.Coupon
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/coupons [post]
+func (h *CouponHandler) CreateCoupon(c *fiber.Ctx) error {
+	var req models.CouponRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateCouponRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+	categories, err := resolveCategoryRefs(h.db, req.Categories)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	cp, err := scanCoupon(h.db.QueryRow(
+		`INSERT INTO coupons (code, description, discount_type, discount_value, min_order_amount, product_ids, categories,
+			starts_at, ends_at, usage_limit, per_user_limit, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING `+couponColumns,
+		req.Code, req.Description, req.DiscountType, req.DiscountValue, req.MinOrderAmount, pq.Array(uuidStrings(req.ProductIDs)),
+		pq.Array(categories), req.StartsAt, req.EndsAt, req.UsageLimit, req.PerUserLimit, isActive,
+	))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{"error": "Coupon code already exists"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create coupon"})
+	}
+	return c.Status(201).JSON(cp)
+}
+
+// @Summary Update a coupon (admin)
+// @Tags Coupons
+// @Accept json
+// @Produce json
+// @Param id path string true "Coupon ID"
+// @Param coupon body models.CouponRequest true "Coupon data"
+// @Success 200 {object} models.Coupon
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/coupons/{id} [put]
+func (h *CouponHandler) UpdateCoupon(c *fiber.Ctx) error {
+	var req models.CouponRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateCouponRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+	categories, err := resolveCategoryRefs(h.db, req.Categories)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	cp, err := scanCoupon(h.db.QueryRow(
+		`UPDATE coupons SET code = $1, description = $2, discount_type = $3, discount_value = $4, min_order_amount = $5,
+			product_ids = $6, categories = $7, starts_at = $8, ends_at = $9, usage_limit = $10, per_user_limit = $11,
+			is_active = $12, updated_at = NOW()
+		WHERE id = $13
+		RETURNING `+couponColumns,
+		req.Code, req.Description, req.DiscountType, req.DiscountValue, req.MinOrderAmount, pq.Array(uuidStrings(req.ProductIDs)),
+		pq.Array(categories), req.StartsAt, req.EndsAt, req.UsageLimit, req.PerUserLimit, isActive, c.Params("id"),
+	))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return c.Status(409).JSON(fiber.Map{"error": "Coupon code already exists"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update coupon"})
+	}
+	return c.Status(200).JSON(cp)
+}
+
+// @Summary Deactivate a coupon (admin)
+// @Description Coupons are deactivated rather than deleted so redemption history is kept.
+// @Tags Coupons
+// @Param id path string true "Coupon ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/coupons/{id} [delete]
+func (h *CouponHandler) DeleteCoupon(c *fiber.Ctx) error {
+	res, err := h.db.Exec(`UPDATE coupons SET is_active = false, updated_at = NOW() WHERE id = $1`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate coupon"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Coupon not found"})
+	}
+	return c.SendStatus(204)
+}
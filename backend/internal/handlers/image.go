@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"ecommerce-backend/internal/media"
+	"ecommerce-backend/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Most images accepted in one gallery upload.
+const maxImagesPerUpload = 10
+
+const productImageColumns = `id, product_id, url, renditions, COALESCE(alt_text, ''), position, is_primary, created_at`
+
+func scanProductImage(row interface{ Scan(...interface{}) error }) (models.ProductImage, error) {
+	var img models.ProductImage
+	var renditions []byte
+	err := row.Scan(&img.ID, &img.ProductID, &img.URL, &renditions, &img.AltText, &img.Position, &img.IsPrimary, &img.CreatedAt)
+	if err == nil {
+		err = json.Unmarshal(renditions, &img.Renditions)
+	}
+	return img, err
+}
+
+// saveImage validates an uploaded image and stores its renditions.
+func (h *ProductHandler) saveImage(ctx context.Context, file *multipart.FileHeader) (*media.Image, error) {
+	image, err := h.images.ProcessFile(ctx, file)
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		return nil, fiber.NewError(413, fmt.Sprintf("Image %q is too large; the limit is %d MB", file.Filename, h.images.MaxBytes()>>20))
+	case errors.Is(err, media.ErrUnsupported):
+		return nil, fiber.NewError(400, fmt.Sprintf("%q is not a JPEG, PNG, GIF or WebP image", file.Filename))
+	case err != nil:
+		return nil, fiber.NewError(500, "Failed to save image")
+	}
+	return image, nil
+}
+
+// attachProductImages loads the galleries of products in one query.
+func attachProductImages(q querier, products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	rows, err := q.Query(
+		`SELECT `+productImageColumns+` FROM product_images WHERE product_id = ANY($1) ORDER BY position, created_at`,
+		pq.Array(uuidStrings(ids)),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byProduct := map[uuid.UUID][]models.ProductImage{}
+	for rows.Next() {
+		img, err := scanProductImage(rows)
+		if err != nil {
+			return err
+		}
+		byProduct[img.ProductID] = append(byProduct[img.ProductID], img)
+	}
+	for i := range products {
+		products[i].Images = byProduct[products[i].ID]
+		if products[i].Images == nil {
+			products[i].Images = []models.ProductImage{}
+		}
+	}
+	return rows.Err()
+}
+
+// syncPrimaryImage makes sure a product with images has exactly one primary
+// image, promoting the first one if needed, and mirrors it into
+// products.image_url.
+func syncPrimaryImage(q querier, productID string) error {
+	_, err := q.Exec(
+		`UPDATE product_images SET is_primary = true
+		WHERE id = (SELECT id FROM product_images WHERE product_id = $1 ORDER BY position, created_at LIMIT 1)
+			AND NOT EXISTS (SELECT 1 FROM product_images WHERE product_id = $1 AND is_primary)`,
+		productID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(
+		`UPDATE products SET image_url = COALESCE((SELECT url FROM product_images WHERE product_id = $1 AND is_primary), ''), updated_at = NOW()
+		WHERE id = $1`,
+		productID,
+	)
+	return err
+}
+
+// addProductImage appends an image to a product's gallery, optionally making
+// it the primary image.
+func addProductImage(q querier, productID string, image *media.Image, altText string, primary bool) (models.ProductImage, error) {
+	renditions, err := json.Marshal(image.Renditions)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+	if primary {
+		if _, err := q.Exec(`UPDATE product_images SET is_primary = false WHERE product_id = $1 AND is_primary`, productID); err != nil {
+			return models.ProductImage{}, err
+		}
+	}
+	img, err := scanProductImage(q.QueryRow(
+		`INSERT INTO product_images (product_id, url, renditions, alt_text, position, is_primary)
+		VALUES ($1, $2, $3, NULLIF($4, ''), (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $1), $5)
+		RETURNING `+productImageColumns,
+		productID, image.URL, renditions, altText, primary,
+	))
+	if err != nil {
+		return img, err
+	}
+	return img, syncPrimaryImage(q, productID)
+}
+
+// productImages returns a product's gallery in display order.
+func productImages(q querier, productID string) ([]models.ProductImage, error) {
+	rows, err := q.Query(`SELECT `+productImageColumns+` FROM product_images WHERE product_id = $1 ORDER BY position, created_at`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	images := []models.ProductImage{}
+	for rows.Next() {
+		img, err := scanProductImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+// galleryResponse writes a product's gallery with the given status.
+func (h *ProductHandler) galleryResponse(c *fiber.Ctx, status int, productID string) error {
+	images, err := productImages(h.db, productID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch images"})
+	}
+	return c.Status(status).JSON(images)
+}
+
+// @Summary List product images
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {array} models.ProductImage
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/images [get]
+func (h *ProductHandler) GetImages(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND (COALESCE(is_active, true) OR $2))`, id, c.Locals("role") == "admin").
+		Scan(&exists)
+	if err != nil || !exists {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	return h.galleryResponse(c, 200, id)
+}
+
+// @Summary Upload product images (admin)
+// @Description Adds one or more images to the end of the gallery. alt_text may be repeated, one per image in the same order.
+// @Tags Products
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param images formData file true "Images"
+// @Param alt_text formData string false "Alt text"
+// @Success 201 {array} models.ProductImage
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/images [post]
+func (h *ProductHandler) UploadImages(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := h.fetchProduct(id); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid multipart form"})
+	}
+	files := form.File["images"]
+	if len(files) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "At least one image is required"})
+	}
+	if len(files) > maxImagesPerUpload {
+		return c.Status(400).JSON(fiber.Map{"error": "Too many images in one upload"})
+	}
+	altTexts := form.Value["alt_text"]
+
+	var images []*media.Image
+	for _, file := range files {
+		image, err := h.saveImage(c.UserContext(), file)
+		if err != nil {
+			return errorResponse(c, err)
+		}
+		images = append(images, image)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	// Serialise gallery changes for the product
+	if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock product"})
+	}
+	for i, image := range images {
+		alt := ""
+		if i < len(altTexts) {
+			alt = strings.TrimSpace(altTexts[i])
+		}
+		if _, err := addProductImage(tx, id, image, alt, false); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save image"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save images"})
+	}
+	return h.galleryResponse(c, 201, id)
+}
+
+// @Summary Reorder product images (admin)
+// @Description image_ids must list every image of the product in the new order.
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param order body models.ReorderImagesRequest true "Image order"
+// @Success 200 {array} models.ProductImage
+// @Failure 400 {object} map[string]string
+// @Router /api/products/{id}/images/order [put]
+func (h *ProductHandler) ReorderImages(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req models.ReorderImagesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock product"})
+	}
+
+	var count int
+	var matched bool
+	err = tx.QueryRow(
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE id = ANY($2)) = cardinality($2::uuid[]) FROM product_images WHERE product_id = $1`,
+		id, pq.Array(uuidStrings(req.ImageIDs)),
+	).Scan(&count, &matched)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load images"})
+	}
+	if !matched || count != len(req.ImageIDs) {
+		return c.Status(400).JSON(fiber.Map{"error": "image_ids must list every image of the product exactly once"})
+	}
+	for i, imageID := range req.ImageIDs {
+		if _, err := tx.Exec(`UPDATE product_images SET position = $1 WHERE id = $2`, i, imageID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to reorder images"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reorder images"})
+	}
+	return h.galleryResponse(c, 200, id)
+}
+
+// @Summary Update a product image (admin)
+// @Description Changes the alt text or makes the image the primary one.
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param imageId path string true "Image ID"
+// @Param image body models.UpdateImageRequest true "Image fields"
+// @Success 200 {array} models.ProductImage
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/images/{imageId} [patch]
+func (h *ProductHandler) UpdateImage(c *fiber.Ctx) error {
+	id, imageID := c.Params("id"), c.Params("imageId")
+	var req models.UpdateImageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock product"})
+	}
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM product_images WHERE id = $1 AND product_id = $2)`, imageID, id).Scan(&exists); err != nil || !exists {
+		return c.Status(404).JSON(fiber.Map{"error": "Image not found"})
+	}
+	if req.AltText != nil {
+		if _, err := tx.Exec(`UPDATE product_images SET alt_text = NULLIF($1, '') WHERE id = $2`, strings.TrimSpace(*req.AltText), imageID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update image"})
+		}
+	}
+	if req.IsPrimary != nil && *req.IsPrimary {
+		if _, err := tx.Exec(`UPDATE product_images SET is_primary = false WHERE product_id = $1 AND is_primary`, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update image"})
+		}
+		if _, err := tx.Exec(`UPDATE product_images SET is_primary = true WHERE id = $1`, imageID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update image"})
+		}
+		if err := syncPrimaryImage(tx, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update image"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update image"})
+	}
+	return h.galleryResponse(c, 200, id)
+}
+
+// @Summary Delete a product image (admin)
+// @Description Deleting the primary image promotes the next one.
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param imageId path string true "Image ID"
+// @Success 200 {array} models.ProductImage
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/images/{imageId} [delete]
+func (h *ProductHandler) DeleteImage(c *fiber.Ctx) error {
+	id, imageID := c.Params("id"), c.Params("imageId")
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to lock product"})
+	}
+	res, err := tx.Exec(`DELETE FROM product_images WHERE id = $1 AND product_id = $2`, imageID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete image"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Image not found"})
+	}
+	if err := syncPrimaryImage(tx, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete image"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete image"})
+	}
+	return h.galleryResponse(c, 200, id)
+}
+
+// setPrimaryImage adds image to a product's gallery as its primary image.
+func setPrimaryImage(db *sql.DB, productID string, image *media.Image) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := addProductImage(tx, productID, image, "", true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
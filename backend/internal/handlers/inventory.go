@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type InventoryHandler struct {
+	db                *sql.DB
+	lowStockThreshold int
+}
+
+// NewInventoryHandler returns a handler for stock administration.
+// lowStockThreshold applies to products without their own threshold.
+func NewInventoryHandler(db *sql.DB, lowStockThreshold int) *InventoryHandler {
+	return &InventoryHandler{db: db, lowStockThreshold: lowStockThreshold}
+}
+
+// stockMovement is a change to the stock of a product, or of one of its
+// variants when variantID is set, at a location. An empty locationID means
+// the default location. orderID, transferID and actorID may be empty.
+type stockMovement struct {
+	productID    string
+	variantID    uuid.NullUUID
+	locationID   string
+	quantity     int
+	movementType string
+	reason       string
+	orderID      string
+	transferID   string
+	actorID      string
+}
+
+// recordStockMovement appends m to the ledger and applies it to the stock it
+// describes, both at its location and in total. All stock changes go through
+// here so stock always equals the sum of its movements. Taking more than the
+// location holds violates a check constraint.
+func recordStockMovement(q querier, m stockMovement) error {
+	_, err := insertStockMovement(q, m)
+	return err
+}
+
+// insertStockMovement is recordStockMovement returning the new movement's ID.
+func insertStockMovement(q querier, m stockMovement) (string, error) {
+	if m.quantity == 0 {
+		return "", nil
+	}
+	var id, locationID string
+	err := q.QueryRow(
+		`INSERT INTO stock_movements (product_id, variant_id, location_id, quantity, movement_type, reason, order_id, transfer_id, actor_id)
+		VALUES ($1, $2, COALESCE(NULLIF($3, '')::uuid, (SELECT id FROM locations WHERE is_default)), $4, $5, $6,
+			NULLIF($7, '')::uuid, NULLIF($8, '')::uuid, NULLIF($9, '')::uuid)
+		RETURNING id, location_id`,
+		m.productID, m.variantID, m.locationID, m.quantity, m.movementType, m.reason, m.orderID, m.transferID, m.actorID,
+	).Scan(&id, &locationID)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(
+		`INSERT INTO location_stock (location_id, product_id, variant_id, quantity) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (location_id, product_id, (COALESCE(variant_id, product_id)))
+		DO UPDATE SET quantity = location_stock.quantity + EXCLUDED.quantity, updated_at = NOW()`,
+		locationID, m.productID, m.variantID, m.quantity,
+	)
+	if err != nil {
+		return "", err
+	}
+	if m.variantID.Valid {
+		_, err = q.Exec(`UPDATE product_variants SET stock = stock + $1, updated_at = NOW() WHERE id = $2`, m.quantity, m.variantID)
+	} else {
+		_, err = q.Exec(`UPDATE products SET stock = stock + $1, updated_at = NOW() WHERE id = $2`, m.quantity, m.productID)
+	}
+	return id, err
+}
+
+// setStockLevel records an adjustment that brings a product's total stock, or
+// a variant's when variantID is set, to level. The row is locked first so the
+// difference is taken against the current stock. The difference is made at
+// the default location; stock held elsewhere has to be adjusted per
+// location, which is reported as a 409 *fiber.Error.
+func setStockLevel(q querier, productID string, variantID uuid.NullUUID, level int, actorID string) error {
+	var stock int
+	var err error
+	if variantID.Valid {
+		err = q.QueryRow(`SELECT stock FROM product_variants WHERE id = $1 FOR UPDATE`, variantID).Scan(&stock)
+	} else {
+		err = q.QueryRow(`SELECT stock FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&stock)
+	}
+	if err != nil {
+		return err
+	}
+	if level < stock {
+		_, atDefault, err := locationQuantity(q, "", productID, variantID)
+		if err != nil {
+			return err
+		}
+		if stock-level > atDefault {
+			return fiber.NewError(409, "Stock is held at other locations; adjust it per location")
+		}
+	}
+	return recordStockMovement(q, stockMovement{
+		productID: productID, variantID: variantID, quantity: level - stock,
+		movementType: "adjustment", reason: "Stock level set", actorID: actorID,
+	})
+}
+
+// locationQuantity returns the ID of an active location, the default one when
+// locationID is empty, and how much of a product or variant it holds.
+// sql.ErrNoRows means there is no such location.
+func locationQuantity(q querier, locationID, productID string, variantID uuid.NullUUID) (string, int, error) {
+	var id string
+	var quantity int
+	err := q.QueryRow(
+		`SELECT l.id, COALESCE(s.quantity, 0) FROM locations l
+		LEFT JOIN location_stock s ON s.location_id = l.id AND s.product_id = $2 AND s.variant_id IS NOT DISTINCT FROM $3
+		WHERE l.is_active AND CASE WHEN $1 = '' THEN l.is_default ELSE l.id::text = $1 END`,
+		locationID, productID, variantID,
+	).Scan(&id, &quantity)
+	return id, quantity, err
+}
+
+// restockOrder returns the stock taken by a paid order that is being
+// cancelled or refunded to the locations it was taken from, or to the
+// default location when one has since been deactivated. The movements are
+// recorded as movementType with reason. It only puts back what the order
+// still holds of each item, so calling it twice restocks once.
+func restockOrder(tx *sql.Tx, orderID, actorID, movementType, reason string) error {
+	rows, err := tx.Query(
+		`SELECT product_id, variant_id, location_id, is_active, held, outstanding FROM (
+			SELECT m.product_id, m.variant_id, m.location_id, l.is_active, -SUM(m.quantity) AS held,
+				-SUM(SUM(m.quantity)) OVER (PARTITION BY m.product_id, m.variant_id) AS outstanding
+			FROM stock_movements m JOIN locations l ON l.id = m.location_id
+			WHERE m.order_id = $1
+			GROUP BY m.product_id, m.variant_id, m.location_id, l.is_active
+		) s WHERE held > 0 AND outstanding > 0
+		ORDER BY product_id, variant_id NULLS FIRST, location_id`, orderID)
+	if err != nil {
+		return err
+	}
+	var moves []stockMovement
+	outstanding := map[string]int{}
+	for rows.Next() {
+		m := stockMovement{movementType: movementType, reason: reason, orderID: orderID, actorID: actorID}
+		var active bool
+		var total int
+		if err := rows.Scan(&m.productID, &m.variantID, &m.locationID, &active, &m.quantity, &total); err != nil {
+			rows.Close()
+			return err
+		}
+		if !active {
+			m.locationID = ""
+		}
+		item := m.productID + "/" + m.variantID.UUID.String()
+		if _, ok := outstanding[item]; !ok {
+			outstanding[item] = total
+		}
+		m.quantity = min(m.quantity, outstanding[item])
+		outstanding[item] -= m.quantity
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range moves {
+		// Lock in the order used by placeOrder: product, then variant
+		if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, m.productID); err != nil {
+			return err
+		}
+		if err := recordStockMovement(tx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stockMovementColumns selects a stock_movements row aliased m, joined
+// (LEFT) to its actor as u. Scan the result with scanStockMovement.
+const stockMovementColumns = `m.id, m.product_id, m.variant_id, m.location_id, (SELECT name FROM locations WHERE id = m.location_id),
+	m.quantity, m.movement_type, m.reason, m.order_id, m.transfer_id, m.actor_id, COALESCE(u.full_name, ''), m.created_at`
+
+func scanStockMovement(row interface{ Scan(...interface{}) error }) (models.StockMovement, error) {
+	var m models.StockMovement
+	err := row.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.LocationID, &m.LocationName,
+		&m.Quantity, &m.MovementType, &m.Reason, &m.OrderID, &m.TransferID, &m.ActorID, &m.ActorName, &m.CreatedAt)
+	return m, err
+}
+
+// @Summary Adjust stock (admin)
+// @Description Records a manual adjustment, restock or return at a location (the default location when location_id is not set) and applies it. quantity is the change and may be negative for adjustments.
+// @Tags Inventory
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param adjustment body models.StockAdjustmentRequest true "Adjustment"
+// @Success 201 {object} models.StockMovement
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/products/{id}/stock [post]
+func (h *InventoryHandler) AdjustStock(c *fiber.Ctx) error {
+	productID := c.Params("id")
+	actorID, _ := c.Locals("user_id").(string)
+	var req models.StockAdjustmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.MovementType == "" {
+		req.MovementType = "adjustment"
+	}
+	switch {
+	case req.MovementType != "adjustment" && req.MovementType != "restock" && req.MovementType != "return":
+		return c.Status(400).JSON(fiber.Map{"error": "Movement type must be adjustment, restock or return"})
+	case req.Quantity == 0:
+		return c.Status(400).JSON(fiber.Map{"error": "Quantity must not be zero"})
+	case req.MovementType != "adjustment" && req.Quantity < 0:
+		return c.Status(400).JSON(fiber.Map{"error": "Restocks and returns must add stock"})
+	case req.Reason == "":
+		return c.Status(400).JSON(fiber.Map{"error": "Reason is required"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var stock int
+	err = tx.QueryRow(`SELECT stock FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&stock)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	m := stockMovement{productID: productID, quantity: req.Quantity, movementType: req.MovementType, reason: req.Reason, actorID: actorID}
+	if req.VariantID != nil {
+		m.variantID = uuid.NullUUID{UUID: *req.VariantID, Valid: true}
+		err = tx.QueryRow(`SELECT stock FROM product_variants WHERE id = $1 AND product_id = $2 FOR UPDATE`, *req.VariantID, productID).Scan(&stock)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+		}
+	}
+	locationID := ""
+	if req.LocationID != nil {
+		locationID = req.LocationID.String()
+	}
+	locationID, stock, err = locationQuantity(tx, locationID, productID, m.variantID)
+	if err == sql.ErrNoRows {
+		return c.Status(400).JSON(fiber.Map{"error": "Location not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load location stock"})
+	}
+	if stock+req.Quantity < 0 {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Only %d in stock at this location", stock)})
+	}
+	m.locationID = locationID
+	if req.OrderID != nil {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, *req.OrderID).Scan(&exists); err != nil || !exists {
+			return c.Status(400).JSON(fiber.Map{"error": "Order not found"})
+		}
+		m.orderID = req.OrderID.String()
+	}
+	id, err := insertStockMovement(tx, m)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust stock"})
+	}
+
+	movement, err := scanStockMovement(tx.QueryRow(
+		`SELECT `+stockMovementColumns+` FROM stock_movements m LEFT JOIN users u ON u.id = m.actor_id WHERE m.id = $1`, id))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust stock"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust stock"})
+	}
+	return c.Status(201).JSON(movement)
+}
+
+// @Summary Stock movement history (admin)
+// @Description Lists a product's stock movements newest first, or those of one variant with variant_id. location_id limits the history to one location.
+// @Tags Inventory
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variant_id query string false "Variant ID"
+// @Param location_id query string false "Location ID"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {object} models.StockHistoryResponse
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/products/{id}/stock-movements [get]
+func (h *InventoryHandler) GetStockMovements(c *fiber.Ctx) error {
+	productID := c.Params("id")
+	variantID, err := variantQuery(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, 200)
+
+	var resp models.StockHistoryResponse
+	resp.Movements = []models.StockMovement{}
+	resp.Page, resp.Limit = page, limit
+	if variantID.Valid {
+		err = h.db.QueryRow(`SELECT product_id, id, stock FROM product_variants WHERE id = $1 AND product_id = $2`, variantID.UUID, productID).
+			Scan(&resp.ProductID, &resp.VariantID, &resp.Stock)
+	} else {
+		err = h.db.QueryRow(`SELECT id, stock FROM products WHERE id = $1`, productID).Scan(&resp.ProductID, &resp.Stock)
+	}
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	locationID := c.Query("location_id")
+	if locationID != "" {
+		id, err := uuid.Parse(locationID)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid location ID"})
+		}
+		resp.LocationID, locationID = &id, id.String()
+		err = h.db.QueryRow(
+			`SELECT COALESCE(SUM(quantity), 0) FROM location_stock WHERE location_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM $3`,
+			id, productID, variantID,
+		).Scan(&resp.Stock)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock movements"})
+		}
+	}
+
+	// Product stock and each variant's stock are separate balances
+	where := ` WHERE m.product_id = $1 AND m.variant_id IS NOT DISTINCT FROM $2 AND ($3 = '' OR m.location_id::text = $3)`
+	err = h.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(m.quantity), 0) FROM stock_movements m`+where, productID, variantID, locationID).
+		Scan(&resp.Total, &resp.LedgerStock)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock movements"})
+	}
+	rows, err := h.db.Query(
+		`SELECT `+stockMovementColumns+` FROM stock_movements m LEFT JOIN users u ON u.id = m.actor_id`+where+`
+		ORDER BY m.created_at DESC, m.id LIMIT $4 OFFSET $5`,
+		productID, variantID, locationID, limit, (page-1)*limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch stock movements"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanStockMovement(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan stock movement"})
+		}
+		resp.Movements = append(resp.Movements, m)
+	}
+	return c.Status(200).JSON(resp)
+}
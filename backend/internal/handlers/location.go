@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type LocationHandler struct {
+	db *sql.DB
+}
+
+func NewLocationHandler(db *sql.DB) *LocationHandler {
+	return &LocationHandler{db: db}
+}
+
+// nullUUID converts an optional ID from a request body.
+func nullUUID(id *uuid.UUID) uuid.NullUUID {
+	if id == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: *id, Valid: true}
+}
+
+const locationColumns = `l.id, l.code, l.name, l.location_type, l.address, l.city, l.latitude, l.longitude, l.priority,
+	l.pickup_enabled, l.is_default, l.is_active, l.created_at, l.updated_at`
+
+func scanLocation(row interface{ Scan(...interface{}) error }) (models.Location, error) {
+	var l models.Location
+	err := row.Scan(&l.ID, &l.Code, &l.Name, &l.LocationType, &l.Address, &l.City, &l.Latitude, &l.Longitude, &l.Priority,
+		&l.PickupEnabled, &l.IsDefault, &l.IsActive, &l.CreatedAt, &l.UpdatedAt)
+	return l, err
+}
+
+func (h *LocationHandler) listLocations(c *fiber.Ctx, where string) error {
+	rows, err := h.db.Query(`SELECT ` + locationColumns + ` FROM locations l` + where + ` ORDER BY l.priority, l.code`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch locations"})
+	}
+	defer rows.Close()
+	locations := []models.Location{}
+	for rows.Next() {
+		l, err := scanLocation(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan location"})
+		}
+		locations = append(locations, l)
+	}
+	return c.Status(200).JSON(locations)
+}
+
+// @Summary List locations
+// @Description Active warehouses and shops. With pickup=true only shops orders can be collected from.
+// @Tags Locations
+// @Produce json
+// @Param pickup query bool false "Only pickup locations"
+// @Success 200 {array} models.Location
+// @Router /api/locations [get]
+func (h *LocationHandler) GetLocations(c *fiber.Ctx) error {
+	where := ` WHERE l.is_active`
+	if c.QueryBool("pickup") {
+		where += ` AND l.pickup_enabled`
+	}
+	return h.listLocations(c, where)
+}
+
+// @Summary List all locations (admin)
+// @Tags Locations
+// @Produce json
+// @Success 200 {array} models.Location
+// @Security BearerAuth
+// @Router /api/admin/locations [get]
+func (h *LocationHandler) GetAllLocations(c *fiber.Ctx) error {
+	return h.listLocations(c, "")
+}
+
+// validateLocationRequest trims and checks a location request.
+func validateLocationRequest(req *models.LocationRequest) error {
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	req.Name = strings.TrimSpace(req.Name)
+	req.City = strings.TrimSpace(req.City)
+	req.Address = strings.TrimSpace(req.Address)
+	switch {
+	case req.Code == "" || req.Name == "" || req.City == "":
+		return fiber.NewError(400, "Code, name and city are required")
+	case req.LocationType != "warehouse" && req.LocationType != "store":
+		return fiber.NewError(400, "Location type must be warehouse or store")
+	case req.Latitude == nil || req.Longitude == nil:
+		return fiber.NewError(400, "Latitude and longitude are required")
+	case *req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180:
+		return fiber.NewError(400, "Latitude or longitude is out of range")
+	case req.PickupEnabled && req.LocationType != "store":
+		return fiber.NewError(400, "Only stores can offer pickup")
+	case req.IsDefault != nil && *req.IsDefault && req.IsActive != nil && !*req.IsActive:
+		return fiber.NewError(400, "The default location must be active")
+	}
+	return nil
+}
+
+// @Summary Create a location (admin)
+// @Tags Locations
+// @Accept json
+// @Produce json
+// @Param location body models.LocationRequest true "Location"
+// @Success 201 {object} models.Location
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/locations [post]
+func (h *LocationHandler) CreateLocation(c *fiber.Ctx) error {
+	var req models.LocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateLocationRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+	priority := 100
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	active := req.IsActive == nil || *req.IsActive
+	isDefault := req.IsDefault != nil && *req.IsDefault
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	if isDefault {
+		if _, err := tx.Exec(`UPDATE locations SET is_default = false, updated_at = NOW() WHERE is_default`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create location"})
+		}
+	}
+	l, err := scanLocation(tx.QueryRow(
+		`INSERT INTO locations AS l (code, name, location_type, address, city, latitude, longitude, priority, pickup_enabled, is_default, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING `+locationColumns,
+		req.Code, req.Name, req.LocationType, req.Address, req.City, *req.Latitude, *req.Longitude, priority, req.PickupEnabled, isDefault, active,
+	))
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "Location code already exists"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create location"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create location"})
+	}
+	return c.Status(201).JSON(l)
+}
+
+// @Summary Update a location (admin)
+// @Description Replaces a location's details. Making a location the default moves the flag from the current default, which cannot be unset otherwise. Leaving is_default out keeps the current flag. A location can only be deactivated once it holds no stock and has no transfers in transit.
+// @Tags Locations
+// @Accept json
+// @Produce json
+// @Param id path string true "Location ID"
+// @Param location body models.LocationRequest true "Location"
+// @Success 200 {object} models.Location
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/locations/{id} [put]
+func (h *LocationHandler) UpdateLocation(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req models.LocationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateLocationRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	current, err := scanLocation(tx.QueryRow(`SELECT `+locationColumns+` FROM locations l WHERE l.id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Location not found"})
+	}
+	priority := current.Priority
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	active := current.IsActive
+	if req.IsActive != nil {
+		active = *req.IsActive
+	}
+	isDefault := current.IsDefault
+	if req.IsDefault != nil {
+		isDefault = *req.IsDefault
+	}
+	if current.IsDefault && !isDefault {
+		return c.Status(409).JSON(fiber.Map{"error": "Make another location the default instead"})
+	}
+	if isDefault && !active {
+		return c.Status(409).JSON(fiber.Map{"error": "The default location must be active"})
+	}
+	if current.IsActive && !active {
+		var busy bool
+		err := tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM location_stock WHERE location_id = $1 AND quantity > 0)
+				OR EXISTS (SELECT 1 FROM stock_transfers WHERE $1 IN (from_location_id, to_location_id) AND status = 'in_transit')`, id,
+		).Scan(&busy)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update location"})
+		}
+		if busy {
+			return c.Status(409).JSON(fiber.Map{"error": "Transfer this location's stock elsewhere before deactivating it"})
+		}
+	}
+	if isDefault && !current.IsDefault {
+		if _, err := tx.Exec(`UPDATE locations SET is_default = false, updated_at = NOW() WHERE is_default`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update location"})
+		}
+	}
+	l, err := scanLocation(tx.QueryRow(
+		`UPDATE locations l SET code = $1, name = $2, location_type = $3, address = $4, city = $5, latitude = $6, longitude = $7,
+			priority = $8, pickup_enabled = $9, is_default = $10, is_active = $11, updated_at = NOW()
+		WHERE id = $12 RETURNING `+locationColumns,
+		req.Code, req.Name, req.LocationType, req.Address, req.City, *req.Latitude, *req.Longitude,
+		priority, req.PickupEnabled, isDefault, active, id,
+	))
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "Location code already exists"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update location"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update location"})
+	}
+	return c.Status(200).JSON(l)
+}
+
+// @Summary Stock held at a location (admin)
+// @Tags Locations
+// @Produce json
+// @Param id path string true "Location ID"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {object} models.LocationStockResponse
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/locations/{id}/stock [get]
+func (h *LocationHandler) GetLocationStock(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, 200)
+
+	var resp models.LocationStockResponse
+	var err error
+	resp.Location, err = scanLocation(h.db.QueryRow(`SELECT `+locationColumns+` FROM locations l WHERE l.id = $1`, c.Params("id")))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Location not found"})
+	}
+	resp.Items = []models.LocationStockItem{}
+	resp.Page, resp.Limit = page, limit
+	err = h.db.QueryRow(`SELECT COUNT(*) FROM location_stock WHERE location_id = $1 AND quantity > 0`, resp.Location.ID).Scan(&resp.Total)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch location stock"})
+	}
+	rows, err := h.db.Query(
+		`SELECT s.product_id, s.variant_id, p.name, `+variantLabelExpr+`, COALESCE(v.sku, p.sku, ''), s.quantity
+		FROM location_stock s
+		JOIN products p ON p.id = s.product_id
+		LEFT JOIN product_variants v ON v.id = s.variant_id
+		WHERE s.location_id = $1 AND s.quantity > 0
+		ORDER BY p.name, s.variant_id NULLS FIRST LIMIT $2 OFFSET $3`,
+		resp.Location.ID, limit, (page-1)*limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch location stock"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.LocationStockItem
+		if err := rows.Scan(&item.ProductID, &item.VariantID, &item.ProductName, &item.VariantLabel, &item.SKU, &item.Quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan location stock"})
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary Product availability by location
+// @Description Total availability of a product, or of one variant with variant_id, and the stock each active location holds. Useful for choosing a pickup shop.
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variant_id query string false "Variant ID"
+// @Success 200 {object} models.ProductAvailability
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/availability [get]
+func (h *ProductHandler) GetAvailability(c *fiber.Ctx) error {
+	variantID, err := variantQuery(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	var resp models.ProductAvailability
+	if variantID.Valid {
+		err = h.db.QueryRow(
+			`SELECT v.product_id, v.id, v.stock, `+variantAvailableStockExpr+` FROM product_variants v
+			JOIN products p ON p.id = v.product_id
+			WHERE v.id = $1 AND v.product_id = $2 AND v.is_active AND COALESCE(p.is_active, true)`,
+			variantID.UUID, c.Params("id"),
+		).Scan(&resp.ProductID, &resp.VariantID, &resp.Stock, &resp.Available)
+	} else {
+		err = h.db.QueryRow(
+			`SELECT p.id, p.stock, `+availableStockExpr+` FROM products p WHERE p.id = $1 AND COALESCE(p.is_active, true)`, c.Params("id"),
+		).Scan(&resp.ProductID, &resp.Stock, &resp.Available)
+	}
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	resp.Available = max(resp.Available, 0)
+
+	// A product sold as variants is held as its variants
+	rows, err := h.db.Query(
+		`SELECT l.id, l.name, l.location_type, l.city, l.pickup_enabled, COALESCE(SUM(s.quantity), 0)
+		FROM locations l
+		LEFT JOIN location_stock s ON s.location_id = l.id AND s.product_id = $1
+			AND (CASE WHEN $2::uuid IS NOT NULL THEN s.variant_id = $2
+				WHEN EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = $1 AND v.is_active)
+					THEN s.variant_id IN (SELECT v.id FROM product_variants v WHERE v.product_id = $1 AND v.is_active)
+				ELSE s.variant_id IS NULL END)
+		WHERE l.is_active
+		GROUP BY l.id ORDER BY l.priority, l.code`,
+		resp.ProductID, variantID,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch availability"})
+	}
+	defer rows.Close()
+	resp.Locations = []models.LocationAvailability{}
+	for rows.Next() {
+		var l models.LocationAvailability
+		if err := rows.Scan(&l.LocationID, &l.Name, &l.LocationType, &l.City, &l.PickupEnabled, &l.Quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan availability"})
+		}
+		resp.Locations = append(resp.Locations, l)
+	}
+	return c.Status(200).JSON(resp)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"ecommerce-backend/internal/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// @Summary Low-stock alerts (admin)
+// @Description Lists alerts raised by the low-stock check, newest first, with current availability.
+// @Tags Inventory
+// @Produce json
+// @Param status query string false "open (default), resolved or all"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {object} models.LowStockAlertsResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/alerts [get]
+func (h *InventoryHandler) GetLowStockAlerts(c *fiber.Ctx) error {
+	var where string
+	switch c.Query("status", "open") {
+	case "open":
+		where = ` WHERE a.resolved_at IS NULL`
+	case "resolved":
+		where = ` WHERE a.resolved_at IS NOT NULL`
+	case "all":
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be open, resolved or all"})
+	}
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, 200)
+
+	resp := models.LowStockAlertsResponse{Alerts: []models.LowStockAlert{}, Page: page, Limit: limit}
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM low_stock_alerts a` + where).Scan(&resp.Total); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch alerts"})
+	}
+	rows, err := h.db.Query(
+		`SELECT a.id, a.product_id, a.variant_id, p.name, `+variantLabelExpr+`, COALESCE(v.sku, p.sku, ''),
+			a.threshold, a.available, l.available, a.notified_at, a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.created_at
+		FROM low_stock_alerts a
+		JOIN products p ON p.id = a.product_id
+		LEFT JOIN product_variants v ON v.id = a.variant_id
+		LEFT JOIN inventory_levels l ON l.product_id = a.product_id AND l.variant_id IS NOT DISTINCT FROM a.variant_id`+where+`
+		ORDER BY a.created_at DESC, a.id LIMIT $1 OFFSET $2`,
+		limit, (page-1)*limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch alerts"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.LowStockAlert
+		err := rows.Scan(&a.ID, &a.ProductID, &a.VariantID, &a.ProductName, &a.VariantLabel, &a.SKU,
+			&a.Threshold, &a.AvailableAtAlert, &a.Available, &a.NotifiedAt, &a.AcknowledgedAt, &a.AcknowledgedBy, &a.ResolvedAt, &a.CreatedAt)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan alert"})
+		}
+		resp.Alerts = append(resp.Alerts, a)
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary Acknowledge a low-stock alert (admin)
+// @Description Marks an alert as seen. It stays open until stock is back above the threshold.
+// @Tags Inventory
+// @Param id path string true "Alert ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/alerts/{id}/acknowledge [post]
+func (h *InventoryHandler) AcknowledgeLowStockAlert(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	res, err := h.db.Exec(
+		`UPDATE low_stock_alerts SET acknowledged_at = COALESCE(acknowledged_at, NOW()), acknowledged_by = COALESCE(acknowledged_by, $1)
+		WHERE id = $2`,
+		userID, c.Params("id"),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to acknowledge alert"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Alert not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// @Summary Low-stock digest (admin)
+// @Description Everything whose available stock is at or below its threshold right now, lowest first. This is what the daily digest email summarises.
+// @Tags Inventory
+// @Produce json
+// @Success 200 {object} models.LowStockDigest
+// @Security BearerAuth
+// @Router /api/admin/inventory/low-stock [get]
+func (h *InventoryHandler) GetLowStockDigest(c *fiber.Ctx) error {
+	rows, err := h.db.Query(
+		`SELECT l.product_id, l.variant_id, l.name, l.variant_label, l.sku, l.stock, l.available, COALESCE(l.low_stock_threshold, $1), a.id
+		FROM inventory_levels l
+		LEFT JOIN low_stock_alerts a ON a.product_id = l.product_id AND a.variant_id IS NOT DISTINCT FROM l.variant_id AND a.resolved_at IS NULL
+		WHERE l.available <= COALESCE(l.low_stock_threshold, $1)
+		ORDER BY l.available, l.name, l.variant_label`,
+		h.lowStockThreshold,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch low-stock items"})
+	}
+	defer rows.Close()
+	digest := models.LowStockDigest{GeneratedAt: time.Now(), DefaultThreshold: h.lowStockThreshold, Items: []models.LowStockItem{}}
+	for rows.Next() {
+		var item models.LowStockItem
+		err := rows.Scan(&item.ProductID, &item.VariantID, &item.ProductName, &item.VariantLabel, &item.SKU,
+			&item.Stock, &item.Available, &item.Threshold, &item.AlertID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan low-stock item"})
+		}
+		digest.Items = append(digest.Items, item)
+	}
+	return c.Status(200).JSON(digest)
+}
+
+// @Summary Set a product's low-stock threshold (admin)
+// @Description Alerts are raised when available stock drops to the threshold. Variants share their product's threshold. Send null to use the default.
+// @Tags Inventory
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param threshold body models.LowStockThresholdRequest true "Threshold"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/products/{id}/low-stock-threshold [put]
+func (h *InventoryHandler) SetLowStockThreshold(c *fiber.Ctx) error {
+	var req models.LowStockThresholdRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.LowStockThreshold != nil && *req.LowStockThreshold < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Threshold must not be negative"})
+	}
+	res, err := h.db.Exec(`UPDATE products SET low_stock_threshold = $1, updated_at = NOW() WHERE id = $2`, req.LowStockThreshold, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update threshold"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	threshold := h.lowStockThreshold
+	if req.LowStockThreshold != nil {
+		threshold = *req.LowStockThreshold
+	}
+	return c.Status(200).JSON(fiber.Map{
+		"product_id":          c.Params("id"),
+		"low_stock_threshold": req.LowStockThreshold,
+		"effective_threshold": threshold,
+	})
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LoyaltyProgram holds the rules for earning and redeeming loyalty points.
+type LoyaltyProgram struct {
+	PointsPerKES float64
+	PointValue   float64
+	PointsTTL    time.Duration
+}
+
+type LoyaltyHandler struct {
+	db      *sql.DB
+	program LoyaltyProgram
+}
+
+func NewLoyaltyHandler(db *sql.DB, program LoyaltyProgram) *LoyaltyHandler {
+	return &LoyaltyHandler{db: db, program: program}
+}
+
+// lockUser serialises wallet and points changes for a user by locking their
+// users row.
+func lockUser(tx *sql.Tx, userID string) error {
+	var id string
+	return tx.QueryRow(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+}
+
+// loyaltyBalance sums a user's loyalty points ledger.
+func loyaltyBalance(q querier, userID string) (int, error) {
+	var balance int
+	err := q.QueryRow(`SELECT COALESCE(SUM(points), 0) FROM loyalty_points_ledger WHERE user_id = $1`, userID).Scan(&balance)
+	return balance, err
+}
+
+// redeemLoyaltyPoints spends up to points of a user's balance as a discount on
+// an order worth total. Points are only taken in whole units and never for
+// more than the order is worth. It returns the points used and the discount.
+func redeemLoyaltyPoints(tx *sql.Tx, userID, orderID string, points int, program LoyaltyProgram, total float64) (int, float64, error) {
+	if program.PointValue <= 0 {
+		return 0, 0, fiber.NewError(400, "Loyalty points cannot be redeemed")
+	}
+	if err := lockUser(tx, userID); err != nil {
+		return 0, 0, fiber.NewError(500, "Failed to load loyalty points")
+	}
+	balance, err := loyaltyBalance(tx, userID)
+	if err != nil {
+		return 0, 0, fiber.NewError(500, "Failed to load loyalty points")
+	}
+	if points > balance {
+		return 0, 0, fiber.NewError(409, "Not enough loyalty points")
+	}
+
+	used := min(points, int(math.Floor(total/program.PointValue)))
+	if used <= 0 {
+		return 0, 0, nil
+	}
+	_, err = tx.Exec(`INSERT INTO loyalty_points_ledger (user_id, points, entry_type, order_id) VALUES ($1, $2, 'redeem', $3)`, userID, -used, orderID)
+	if err != nil {
+		return 0, 0, fiber.NewError(500, "Failed to redeem loyalty points")
+	}
+	return used, roundMoney(float64(used) * program.PointValue), nil
+}
+
+// awardLoyaltyPoints credits the customer of a paid order with points for
+// what they paid once it is delivered. Guest orders earn nothing and an
+// order is only ever awarded once.
+func awardLoyaltyPoints(tx *sql.Tx, orderID string, program LoyaltyProgram) error {
+	var userID sql.NullString
+	var total float64
+	if err := tx.QueryRow(`SELECT user_id, total_amount FROM orders WHERE id = $1`, orderID).Scan(&userID, &total); err != nil {
+		return err
+	}
+	points := int(math.Floor(total * program.PointsPerKES))
+	if !userID.Valid || points <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(
+		`INSERT INTO loyalty_points_ledger (user_id, points, entry_type, order_id, expires_at)
+		VALUES ($1, $2, 'earn', $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (order_id, entry_type) WHERE entry_type IN ('earn', 'reversal') DO NOTHING`,
+		userID.String, points, orderID, program.PointsTTL.Seconds(),
+	)
+	return err
+}
+
+// reverseLoyaltyPoints undoes the points side of an order that has been
+// cancelled or refunded: points it earned are taken back and points spent on
+// it are returned. It is safe to call more than once.
+func reverseLoyaltyPoints(tx *sql.Tx, orderID string) error {
+	var userID sql.NullString
+	if err := tx.QueryRow(`SELECT user_id FROM orders WHERE id = $1`, orderID).Scan(&userID); err != nil {
+		return err
+	}
+	if !userID.Valid {
+		return nil
+	}
+	if err := lockUser(tx, userID.String); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO loyalty_points_ledger (user_id, points, entry_type, order_id)
+		SELECT user_id, -points, 'reversal', order_id FROM loyalty_points_ledger WHERE order_id = $1 AND entry_type = 'earn'
+		ON CONFLICT (order_id, entry_type) WHERE entry_type IN ('earn', 'reversal') DO NOTHING`,
+		orderID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var spent int
+	err = tx.QueryRow(`SELECT COALESCE(-SUM(points), 0) FROM loyalty_points_ledger WHERE order_id = $1 AND entry_type IN ('redeem', 'refund')`, orderID).Scan(&spent)
+	if err != nil || spent <= 0 {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO loyalty_points_ledger (user_id, points, entry_type, order_id) VALUES ($1, $2, 'refund', $3)`, userID.String, spent, orderID)
+	return err
+}
+
+// @Summary Get my loyalty points
+// @Tags Loyalty
+// @Produce json
+// @Success 200 {object} models.LoyaltyResponse
+// @Router /api/auth/profile/loyalty [get]
+func (h *LoyaltyHandler) GetMyPoints(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	balance, err := loyaltyBalance(h.db, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch loyalty points"})
+	}
+	resp := models.LoyaltyResponse{
+		Balance:    balance,
+		PointValue: h.program.PointValue,
+		Value:      roundMoney(float64(max(balance, 0)) * h.program.PointValue),
+		Entries:    []models.LoyaltyEntry{},
+	}
+
+	rows, err := h.db.Query(
+		`SELECT id, points, entry_type, order_id, expires_at, created_at FROM loyalty_points_ledger
+		WHERE user_id = $1 ORDER BY created_at DESC LIMIT 100`, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch loyalty points"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.LoyaltyEntry
+		if err := rows.Scan(&e.ID, &e.Points, &e.EntryType, &e.OrderID, &e.ExpiresAt, &e.CreatedAt); err == nil {
+			resp.Entries = append(resp.Entries, e)
+		}
+	}
+	return c.Status(200).JSON(resp)
+}
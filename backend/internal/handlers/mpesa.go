@@ -1,80 +1,287 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"ecommerce-backend/internal/models"
-	"time"
+	"encoding/hex"
+	"log"
+	"math"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
 type MpesaHandler struct {
-	db *sql.DB
+	db            *sql.DB
+	webhookSecret string
 }
 
-func NewMpesaHandler(db *sql.DB) *MpesaHandler {
-	return &MpesaHandler{db: db}
+func NewMpesaHandler(db *sql.DB, webhookSecret string) *MpesaHandler {
+	return &MpesaHandler{db: db, webhookSecret: webhookSecret}
 }
 
 // @Summary Simulate M-Pesa STK Push
-// @Description Simulates an M-Pesa payment for an order
+// @Description Starts a simulated M-Pesa payment for a pending order and records a pending transaction. The amount charged is what is left after gift cards and store credit.
 // @Tags Mpesa
 // @Accept json
 // @Produce json
 // @Param request body models.MpesaSTKPushRequest true "STK Push data"
 // @Success 200 {object} models.MpesaSTKPushResponse
 // @Failure 400 {object} map[string]string
-// @Security BearerAuth
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /api/mpesa/stkpush [post]
 func (h *MpesaHandler) InitiateSTKPush(c *fiber.Ctx) error {
 	var req models.MpesaSTKPushRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	if req.OrderID == uuid.Nil || strings.TrimSpace(req.Phone) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Order ID and phone are required"})
+	}
+
+	var orderUserID sql.NullString
+	var status string
+	var total float64
+	err := h.db.QueryRow(`SELECT user_id, status, amount_due FROM orders WHERE id = $1`, req.OrderID).
+		Scan(&orderUserID, &status, &total)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+	}
+	// Orders placed by a signed-in user can only be paid by that user
+	if orderUserID.Valid {
+		userID, _ := c.Locals("user_id").(string)
+		if userID != orderUserID.String {
+			return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+		}
+	}
+	if status != "pending" {
+		return c.Status(409).JSON(fiber.Map{"error": "Order is not awaiting payment"})
+	}
+	// A payment that could not be applied is refunded or settled by staff;
+	// charging the customer again would take the money twice
+	var paid bool
+	if err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM transactions WHERE order_id = $1 AND status = 'success')`, req.OrderID).Scan(&paid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check payments"})
+	}
+	if paid {
+		return c.Status(409).JSON(fiber.Map{"error": "A payment for this order is being reviewed"})
+	}
+
 	// Simulate payment processing
 	transactionID := uuid.New()
-	ref := "MPESA" + transactionID.String()[:8]
+	ref := "MPESA" + strings.ToUpper(transactionID.String()[:8])
 	resp := models.MpesaSTKPushResponse{
 		TransactionID: transactionID,
 		MpesaRef:      ref,
 		Status:        "pending",
-		Amount:        req.Amount,
-		CreatedAt:     time.Now(),
+		Amount:        total,
+	}
+	err = h.db.QueryRow(
+		`INSERT INTO transactions (id, order_id, mpesa_ref, status, amount, phone_number) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`,
+		transactionID, req.OrderID, ref, "pending", total, req.Phone,
+	).Scan(&resp.CreatedAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to record transaction"})
 	}
-	// Optionally: Save transaction to DB here
 	return c.JSON(resp)
 }
 
 // @Summary Get M-Pesa transaction status
-// @Description Returns the status of a simulated M-Pesa transaction
+// @Description Returns the status of a simulated M-Pesa transaction. Only the customer who placed the order can see it; guests must give the order number and checkout email.
 // @Tags Mpesa
 // @Produce json
 // @Param id path string true "Transaction ID"
+// @Param order_number query string false "Order number (guest orders)"
+// @Param email query string false "Checkout email (guest orders)"
 // @Success 200 {object} models.MpesaSTKPushResponse
 // @Failure 404 {object} map[string]string
-// @Security BearerAuth
 // @Router /api/mpesa/transaction/{id} [get]
 func (h *MpesaHandler) GetTransactionStatus(c *fiber.Ctx) error {
-	// Simulate always successful for demo
-	id := c.Params("id")
-	resp := models.MpesaSTKPushResponse{
-		TransactionID: uuid.MustParse(id),
-		MpesaRef:      "MPESA" + id[:8],
-		Status:        "success",
-		Amount:        100.0,
-		CreatedAt:     time.Now(),
+	var resp models.MpesaSTKPushResponse
+	var orderUserID sql.NullString
+	var orderNumber, email string
+	err := h.db.QueryRow(
+		`SELECT t.id, t.mpesa_ref, t.status, t.amount, t.created_at, o.user_id, o.order_number, COALESCE(o.guest_email, u.email, '')
+		FROM transactions t JOIN orders o ON o.id = t.order_id LEFT JOIN users u ON u.id = o.user_id
+		WHERE t.id = $1`, c.Params("id"),
+	).Scan(&resp.TransactionID, &resp.MpesaRef, &resp.Status, &resp.Amount, &resp.CreatedAt, &orderUserID, &orderNumber, &email)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	if orderUserID.Valid {
+		userID, _ := c.Locals("user_id").(string)
+		if userID != orderUserID.String {
+			return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+		}
+	} else if email == "" || !strings.EqualFold(strings.TrimSpace(c.Query("order_number")), orderNumber) ||
+		!strings.EqualFold(strings.TrimSpace(c.Query("email")), email) {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
 	}
 	return c.JSON(resp)
 }
 
 // @Summary M-Pesa payment webhook (simulated)
-// @Description Receives payment notifications (simulated)
+// @Description Receives the payment result for a transaction. The X-Mpesa-Signature header must be the hex HMAC-SHA256 of the body keyed with the webhook secret. A successful payment must match the transaction's amount and phone; it marks the order paid and converts its stock reservations into stock decrements. When the order can no longer be paid for the payment is kept and flagged for review. Repeated callbacks are ignored.
 // @Tags Mpesa
 // @Accept json
 // @Produce json
+// @Param X-Mpesa-Signature header string true "Hex HMAC-SHA256 of the body"
+// @Param request body models.MpesaWebhookRequest true "Payment result"
 // @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /api/mpesa/webhook [post]
 func (h *MpesaHandler) Webhook(c *fiber.Ctx) error {
-	return c.JSON(fiber.Map{"message": "Webhook received (simulated)"})
+	if !h.validSignature(c.Body(), c.Get("X-Mpesa-Signature")) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid webhook signature"})
+	}
+	var req models.MpesaWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Status != "success" && req.Status != "failed" {
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be success or failed"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var orderID, current, phone string
+	var amount float64
+	err = tx.QueryRow(`SELECT order_id, status, amount, phone_number FROM transactions WHERE id = $1 FOR UPDATE`, req.TransactionID).
+		Scan(&orderID, &current, &amount, &phone)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load transaction"})
+	}
+	if current != "pending" {
+		return c.JSON(fiber.Map{"message": "Transaction already processed", "status": current})
+	}
+	// A payment for a different amount or from another phone does not settle
+	// this transaction
+	if req.Status == "success" && (math.Abs(req.Amount-amount) >= 0.005 || phoneDigits(req.Phone) != phoneDigits(phone)) {
+		return c.Status(409).JSON(fiber.Map{"error": "Payment does not match the transaction"})
+	}
+
+	if _, err := tx.Exec(`UPDATE transactions SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, req.TransactionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update transaction"})
+	}
+	message := "Webhook processed"
+	if req.Status == "success" {
+		// The money has been taken even if the order can no longer be paid
+		// for, so the transaction is kept as successful and flagged for staff
+		// to refund or fulfil by hand
+		if _, err := tx.Exec(`SAVEPOINT confirm_payment`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to confirm payment"})
+		}
+		if err := confirmOrderPayment(tx, orderID); err != nil {
+			reason := errorMessage(err)
+			log.Printf("Payment %s for order %s needs review: %v", req.TransactionID, orderID, err)
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT confirm_payment`); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to confirm payment"})
+			}
+			if _, err := tx.Exec(`UPDATE transactions SET review_reason = $1 WHERE id = $2`, reason, req.TransactionID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to update transaction"})
+			}
+			message = "Payment recorded for review: " + reason
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to commit payment"})
+	}
+	return c.JSON(fiber.Map{"message": message, "status": req.Status})
+}
+
+// @Summary Payments needing review (admin)
+// @Description Successful M-Pesa payments that could not be applied to their order, oldest first. Pass status=all to include resolved ones.
+// @Tags Mpesa
+// @Produce json
+// @Param status query string false "open (default) or all"
+// @Success 200 {array} models.PaymentReview
+// @Security BearerAuth
+// @Router /api/admin/payments/review [get]
+func (h *MpesaHandler) GetPaymentReviews(c *fiber.Ctx) error {
+	where := ` AND t.reviewed_at IS NULL`
+	if c.Query("status", "open") == "all" {
+		where = ""
+	}
+	rows, err := h.db.Query(
+		`SELECT t.id, t.mpesa_ref, t.order_id, o.order_number, o.status, t.amount, t.phone_number, t.review_reason, t.created_at, t.reviewed_at
+		FROM transactions t JOIN orders o ON o.id = t.order_id
+		WHERE t.review_reason IS NOT NULL` + where + ` ORDER BY t.created_at`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch payments"})
+	}
+	defer rows.Close()
+	reviews := []models.PaymentReview{}
+	for rows.Next() {
+		var r models.PaymentReview
+		err := rows.Scan(&r.TransactionID, &r.MpesaRef, &r.OrderID, &r.OrderNumber, &r.OrderStatus, &r.Amount, &r.PhoneNumber, &r.Reason, &r.CreatedAt, &r.ReviewedAt)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan payment"})
+		}
+		reviews = append(reviews, r)
+	}
+	return c.Status(200).JSON(reviews)
+}
+
+// @Summary Resolve a payment review (admin)
+// @Description Marks a flagged payment as handled once it has been refunded or the order fulfilled by hand.
+// @Tags Mpesa
+// @Param id path string true "Transaction ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/payments/{id}/resolve [post]
+func (h *MpesaHandler) ResolvePaymentReview(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	res, err := h.db.Exec(
+		`UPDATE transactions SET reviewed_at = COALESCE(reviewed_at, NOW()), reviewed_by = COALESCE(reviewed_by, $1)
+		WHERE id = $2 AND review_reason IS NOT NULL`,
+		userID, c.Params("id"),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to resolve payment"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Payment not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// validSignature reports whether signature is the hex HMAC-SHA256 of body
+// keyed with the webhook secret. Without a secret nothing is accepted.
+func (h *MpesaHandler) validSignature(body []byte, signature string) bool {
+	if h.webhookSecret == "" {
+		return false
+	}
+	want, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.webhookSecret))
+	mac.Write(body)
+	return hmac.Equal(want, mac.Sum(nil))
+}
+
+// phoneDigits strips everything but digits so "+254 712 345678" and
+// "254712345678" compare equal.
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, phone)
 }
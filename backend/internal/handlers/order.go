@@ -1,28 +1,358 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"ecommerce-backend/internal/models"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
 type OrderHandler struct {
-	db *sql.DB
+	db             *sql.DB
+	reservationTTL time.Duration
+	loyalty        LoyaltyProgram
 }
 
-func NewOrderHandler(db *sql.DB) *OrderHandler {
-	return &OrderHandler{db: db}
+func NewOrderHandler(db *sql.DB, reservationTTL time.Duration, loyalty LoyaltyProgram) *OrderHandler {
+	return &OrderHandler{db: db, reservationTTL: reservationTTL, loyalty: loyalty}
+}
+
+// orderLine is a product, optionally one of its variants, and quantity to be
+// priced and inserted by placeOrder.
+type orderLine struct {
+	ProductID uuid.UUID
+	VariantID uuid.NullUUID
+	Quantity  int
+}
+
+// orderInput carries everything placeOrder needs to create an order.
+// Exactly one of UserID and GuestEmail is set. Orders collected from a shop
+// set PickupLocationID.
+type orderInput struct {
+	UserID           string
+	GuestEmail       string
+	Lines            []orderLine
+	ShippingAddress  string
+	PickupLocationID uuid.NullUUID
+	PhoneNumber      string
+	CouponCode       string
+	GiftCardCode     string
+	UseStoreCredit   bool
+	LoyaltyPoints    int
+	Loyalty          LoyaltyProgram
+	ReservationTTL   time.Duration
+}
+
+func (in orderInput) customer() customerRef {
+	return customerRef{UserID: in.UserID, Email: in.GuestEmail}
+}
+
+// placeOrder inserts an order and its items inside tx. Each line is priced
+// from the current products row (or variant) and checked against available
+// stock, which is then held for the order for ReservationTTL. Product rows
+// are locked in ID order, each followed by its variant rows, so concurrent
+// checkouts serialise without deadlocking. Discounts are worked out per
+// line before the items are written. Loyalty points come off the total
+// next, then any gift card and store credit are spent against it. An order
+// fully covered by them is paid straight away. Client errors are returned
+// as *fiber.Error.
+func placeOrder(tx *sql.Tx, in orderInput) (string, error) {
+	if len(in.Lines) == 0 {
+		return "", fiber.NewError(400, "Order must have at least one item")
+	}
+
+	var userID, guestEmail interface{}
+	if in.UserID != "" {
+		userID = in.UserID
+	} else {
+		guestEmail = in.GuestEmail
+	}
+
+	shippingAddress := in.ShippingAddress
+	if in.PickupLocationID.Valid {
+		var name, address string
+		err := tx.QueryRow(
+			`SELECT name, address FROM locations WHERE id = $1 AND is_active AND pickup_enabled`, in.PickupLocationID,
+		).Scan(&name, &address)
+		if err == sql.ErrNoRows {
+			return "", fiber.NewError(400, "Pickup location not found")
+		}
+		if err != nil {
+			return "", fiber.NewError(500, "Failed to load pickup location")
+		}
+		if shippingAddress == "" {
+			shippingAddress = strings.TrimSuffix("Pickup at "+name+", "+address, ", ")
+		}
+	}
+
+	var orderID string
+	err := tx.QueryRow(
+		`INSERT INTO orders (user_id, guest_email, order_number, status, total_amount, shipping_address, phone_number, pickup_location_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		userID, guestEmail, newOrderNumber(), "pending", 0.0, shippingAddress, in.PhoneNumber, in.PickupLocationID,
+	).Scan(&orderID)
+	if err != nil {
+		return "", fiber.NewError(500, "Failed to create order")
+	}
+
+	sorted := append([]orderLine(nil), in.Lines...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].ProductID != sorted[j].ProductID {
+			return sorted[i].ProductID.String() < sorted[j].ProductID.String()
+		}
+		return sorted[i].VariantID.UUID.String() < sorted[j].VariantID.UUID.String()
+	})
+
+	// Price and reserve every line
+	lines := make([]pricedLine, 0, len(sorted))
+	for _, line := range sorted {
+		if line.Quantity <= 0 {
+			return "", fiber.NewError(400, "Quantity must be greater than zero")
+		}
+		pl := pricedLine{ProductID: line.ProductID, VariantID: line.VariantID, Quantity: line.Quantity}
+		var available int
+		var hasVariants, active bool
+		err := tx.QueryRow(`SELECT p.name, p.category_id, p.price, `+availableStockExpr+`, `+hasVariantsExpr+`, COALESCE(p.is_active, true) FROM products p WHERE p.id = $1 FOR UPDATE`, line.ProductID).
+			Scan(&pl.Name, &pl.CategoryID, &pl.UnitPrice, &available, &hasVariants, &active)
+		if err == sql.ErrNoRows {
+			return "", fiber.NewError(400, "Product not found: "+line.ProductID.String())
+		}
+		if err != nil {
+			return "", fiber.NewError(500, "Failed to load product")
+		}
+		if !active {
+			return "", fiber.NewError(409, pl.Name+" is no longer available")
+		}
+		displayName := pl.Name
+		if line.VariantID.Valid {
+			var label string
+			err := tx.QueryRow(
+				`SELECT COALESCE(v.price, $3), `+variantLabelExpr+`, `+variantAvailableStockExpr+`
+				FROM product_variants v WHERE v.id = $1 AND v.product_id = $2 AND v.is_active FOR UPDATE`,
+				line.VariantID.UUID, line.ProductID, pl.UnitPrice,
+			).Scan(&pl.UnitPrice, &label, &available)
+			if err == sql.ErrNoRows {
+				return "", fiber.NewError(400, "Variant not found: "+line.VariantID.UUID.String())
+			}
+			if err != nil {
+				return "", fiber.NewError(500, "Failed to load product variant")
+			}
+			displayName = variantName(pl.Name, label)
+		} else if hasVariants {
+			return "", fiber.NewError(400, "Choose an option for "+pl.Name)
+		}
+		if available < line.Quantity {
+			return "", fiber.NewError(409, "Insufficient stock for "+displayName)
+		}
+		if err := reserveStock(tx, orderID, line.ProductID, line.VariantID, line.Quantity, in.ReservationTTL); err != nil {
+			return "", fiber.NewError(500, "Failed to reserve stock")
+		}
+		lines = append(lines, pl)
+	}
+
+	// Apply discounts: automatic promotions first, then any coupon
+	promotions, err := applyPromotions(tx, lines)
+	if err != nil {
+		return "", fiber.NewError(500, "Failed to apply promotions")
+	}
+	for _, pr := range promotions {
+		_, err := tx.Exec(`INSERT INTO order_promotions (order_id, promotion_id, discount_amount) VALUES ($1, $2, $3)`, orderID, pr.ID, pr.Discount)
+		if err != nil {
+			return "", fiber.NewError(500, "Failed to record promotion")
+		}
+	}
+
+	var couponID interface{}
+	if in.CouponCode != "" {
+		coupon, err := applyCoupon(tx, in.CouponCode, lines, in.customer(), true)
+		if err != nil {
+			return "", err
+		}
+		if err := redeemCoupon(tx, coupon, orderID, in.customer()); err != nil {
+			return "", fiber.NewError(500, "Failed to redeem coupon")
+		}
+		couponID = coupon.ID
+	}
+
+	var subtotal, discount float64
+	for _, pl := range lines {
+		_, err = tx.Exec(
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, unit_price, discount_amount) VALUES ($1, $2, $3, $4, $5, $6)`,
+			orderID, pl.ProductID, pl.VariantID, pl.Quantity, pl.UnitPrice, pl.Discount,
+		)
+		if err != nil {
+			return "", fiber.NewError(500, "Failed to add order item")
+		}
+		subtotal += pl.total()
+		discount += pl.Discount
+	}
+
+	total := roundMoney(subtotal - discount)
+	var pointsUsed int
+	var loyaltyDiscount float64
+	if in.LoyaltyPoints > 0 {
+		if in.UserID == "" {
+			return "", fiber.NewError(400, "Sign in to redeem loyalty points")
+		}
+		pointsUsed, loyaltyDiscount, err = redeemLoyaltyPoints(tx, in.UserID, orderID, in.LoyaltyPoints, in.Loyalty, total)
+		if err != nil {
+			return "", err
+		}
+		total = roundMoney(total - loyaltyDiscount)
+	}
+
+	// Spend gift card balance and store credit against what is left to pay
+	due := total
+	var giftCardAmount, storeCreditAmount float64
+	if in.GiftCardCode != "" {
+		giftCardAmount, err = redeemGiftCard(tx, in.GiftCardCode, orderID, due)
+		if err != nil {
+			return "", err
+		}
+		due = roundMoney(due - giftCardAmount)
+	}
+	if in.UseStoreCredit && in.UserID != "" && due > 0 {
+		storeCreditAmount, err = spendStoreCredit(tx, in.UserID, orderID, due)
+		if err != nil {
+			return "", err
+		}
+		due = roundMoney(due - storeCreditAmount)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE orders SET subtotal_amount = $1, discount_amount = $2, total_amount = $3, coupon_id = $4,
+			gift_card_amount = $5, store_credit_amount = $6, amount_due = $7, loyalty_points_redeemed = $8, loyalty_discount = $9 WHERE id = $10`,
+		roundMoney(subtotal), roundMoney(discount), total, couponID, giftCardAmount, storeCreditAmount, due, pointsUsed, loyaltyDiscount, orderID,
+	)
+	if err != nil {
+		return "", fiber.NewError(500, "Failed to update order total")
+	}
+	if due <= 0 {
+		if err := confirmOrderPayment(tx, orderID); err != nil {
+			return "", err
+		}
+	}
+	return orderID, nil
+}
+
+// orderColumns selects an order joined (LEFT) to its user as o and u. Scan
+// the result with orderScanDest.
+const orderColumns = `o.id, o.order_number, o.user_id, COALESCE(u.full_name, ''), COALESCE(o.guest_email, ''), o.status,
+	o.subtotal_amount, o.discount_amount, COALESCE((SELECT code FROM coupons WHERE id = o.coupon_id), ''), o.loyalty_points_redeemed, o.loyalty_discount, o.total_amount,
+	o.gift_card_amount, o.store_credit_amount, o.amount_due, o.pickup_location_id, o.fulfillment_location_id, o.created_at, o.updated_at`
+
+func orderScanDest(o *models.Order) []interface{} {
+	return []interface{}{&o.ID, &o.OrderNumber, &o.UserID, &o.UserName, &o.GuestEmail, &o.Status,
+		&o.SubtotalAmount, &o.DiscountAmount, &o.CouponCode, &o.LoyaltyPointsRedeemed, &o.LoyaltyDiscount, &o.TotalAmount,
+		&o.GiftCardAmount, &o.StoreCreditAmount, &o.AmountDue, &o.PickupLocationID, &o.FulfillmentLocationID, &o.CreatedAt, &o.UpdatedAt}
+}
+
+// randomCode returns n random characters from an alphabet without easily
+// confused letters and digits.
+func randomCode(n int) string {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+// newOrderNumber returns a short human-friendly order reference.
+func newOrderNumber() string {
+	return "ORD-" + randomCode(10)
+}
+
+// fetchOrderItems loads the items of an order with their product names and
+// variant details.
+func fetchOrderItems(db *sql.DB, orderID uuid.UUID) ([]models.OrderItem, error) {
+	items := []models.OrderItem{}
+	rows, err := db.Query(
+		`SELECT oi.id, oi.order_id, oi.product_id, oi.variant_id, COALESCE(v.sku, ''), `+orderItemVariantLabel+`,
+			oi.quantity, oi.unit_price, oi.discount_amount, p.name
+		FROM order_items oi JOIN products p ON oi.product_id = p.id LEFT JOIN product_variants v ON v.id = oi.variant_id
+		WHERE oi.order_id = $1`, orderID)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.OrderItem
+		var productName string
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.VariantID, &item.SKU, &item.VariantLabel,
+			&item.Quantity, &item.Price, &item.Discount, &productName); err == nil {
+			item.Product = &models.Product{Name: productName}
+			items = append(items, item)
+		}
+	}
+	return items, rows.Err()
+}
+
+// orderItemVariantLabel is the variant label of an order item whose variant
+// is LEFT JOINed as v.
+const orderItemVariantLabel = `CASE WHEN v.id IS NULL THEN '' ELSE ` + variantLabelExpr + ` END`
+
+// fetchOrder loads an order together with its items and product names.
+func fetchOrder(db *sql.DB, orderID string) (models.Order, error) {
+	var order models.Order
+	err := db.QueryRow(`SELECT `+orderColumns+` FROM orders o LEFT JOIN users u ON o.user_id = u.id WHERE o.id = $1`, orderID).
+		Scan(orderScanDest(&order)...)
+	if err != nil {
+		return order, err
+	}
+
+	order.Items, err = fetchOrderItems(db, order.ID)
+	if err != nil {
+		return order, err
+	}
+
+	promoRows, err := db.Query(`SELECT p.id, p.name, op.discount_amount FROM order_promotions op JOIN promotions p ON p.id = op.promotion_id WHERE op.order_id = $1`, orderID)
+	if err != nil {
+		return order, err
+	}
+	defer promoRows.Close()
+	for promoRows.Next() {
+		var pr models.AppliedPromotion
+		if err := promoRows.Scan(&pr.ID, &pr.Name, &pr.Discount); err == nil {
+			order.Promotions = append(order.Promotions, pr)
+		}
+	}
+	order.Allocations, err = fetchOrderAllocations(db, orderID)
+	return order, err
+}
+
+// errorResponse writes err as a JSON error, using the status carried by a
+// *fiber.Error and falling back to 500.
+func errorResponse(c *fiber.Ctx, err error) error {
+	if e, ok := err.(*fiber.Error); ok {
+		return c.Status(e.Code).JSON(fiber.Map{"error": e.Message})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// errorMessage returns the client-facing message of err.
+func errorMessage(err error) string {
+	if e, ok := err.(*fiber.Error); ok {
+		return e.Message
+	}
+	return err.Error()
 }
 
 // @Summary Create a new order
+// @Description Items are priced from the current catalogue; unit_price in the request is ignored.
 // @Tags Orders
 // @Accept json
 // @Produce json
 // @Param order body models.OrderRequest true "Order data"
 // @Success 201 {object} models.Order
 // @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /api/orders [post]
 func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	var req models.OrderRequest
@@ -38,70 +368,46 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
+	lines := make([]orderLine, 0, len(req.Items))
+	for _, item := range req.Items {
+		line := orderLine{ProductID: item.ProductID, Quantity: item.Quantity}
+		if item.VariantID != nil {
+			line.VariantID = uuid.NullUUID{UUID: *item.VariantID, Valid: true}
+		}
+		lines = append(lines, line)
+	}
+
 	tx, err := h.db.Begin()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
 	}
 	defer tx.Rollback()
 
-	var total float64
-	orderID := ""
-
-	// Insert order
-	err = tx.QueryRow(
-		`INSERT INTO orders (user_id, status, total_amount, shipping_address, phone_number) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		userID, "pending", 0.0, req.ShippingAddress, req.PhoneNumber,
-	).Scan(&orderID)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
-	}
-
-	// Insert order items and calculate total
-	for _, item := range req.Items {
-		// Use unit_price from the request (frontend/cart)
-		_, err := tx.Exec(
-			`INSERT INTO order_items (order_id, product_id, quantity, unit_price) VALUES ($1, $2, $3, $4)`,
-			orderID, item.ProductID, item.Quantity, item.UnitPrice,
-		)
-		if err != nil {
-			tx.Rollback()
-			return c.Status(500).JSON(fiber.Map{"error": "Failed to add order item"})
-		}
-		total += item.UnitPrice * float64(item.Quantity)
-	}
-
-	// Update order total
-	_, err = tx.Exec(`UPDATE orders SET total_amount = $1 WHERE id = $2`, total, orderID)
+	orderID, err := placeOrder(tx, orderInput{
+		UserID:           userID,
+		Lines:            lines,
+		ShippingAddress:  req.ShippingAddress,
+		PickupLocationID: nullUUID(req.PickupLocationID),
+		PhoneNumber:      req.PhoneNumber,
+		CouponCode:       req.CouponCode,
+		GiftCardCode:     req.GiftCardCode,
+		UseStoreCredit:   req.UseStoreCredit,
+		LoyaltyPoints:    req.LoyaltyPoints,
+		Loyalty:          h.loyalty,
+		ReservationTTL:   h.reservationTTL,
+	})
 	if err != nil {
-		tx.Rollback()
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order total"})
+		return errorResponse(c, err)
 	}
 
 	if err := tx.Commit(); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to commit order"})
 	}
 
-	// Fetch and return the created order (simplified)
-	var order models.Order
-	err = h.db.QueryRow(
-		`SELECT o.id, o.user_id, u.full_name, o.status, o.total_amount, o.created_at, o.updated_at FROM orders o JOIN users u ON o.user_id = u.id WHERE o.id = $1`, orderID,
-	).Scan(&order.ID, &order.UserID, &order.UserName, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt)
+	order, err := fetchOrder(h.db, orderID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch created order"})
 	}
-
-	// Fetch order items
-	rows, err := h.db.Query(`SELECT id, order_id, product_id, quantity, unit_price FROM order_items WHERE order_id = $1`, orderID)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var item models.OrderItem
-			if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price); err == nil {
-				order.Items = append(order.Items, item)
-			}
-		}
-	}
-
 	return c.Status(201).JSON(order)
 }
 
@@ -119,7 +425,7 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
-	rows, err := h.db.Query(`SELECT o.id, o.user_id, u.full_name, o.status, o.total_amount, o.created_at, o.updated_at FROM orders o JOIN users u ON o.user_id = u.id WHERE o.user_id = $1 ORDER BY o.created_at DESC`, userUUID)
+	rows, err := h.db.Query(`SELECT `+orderColumns+` FROM orders o LEFT JOIN users u ON o.user_id = u.id WHERE o.user_id = $1 ORDER BY o.created_at DESC`, userUUID)
 	if err != nil {
 		// Log the actual SQL error for debugging
 		println("[GetUserOrders SQL ERROR]", err.Error())
@@ -129,24 +435,9 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		if err := rows.Scan(&order.ID, &order.UserID, &order.UserName, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt); err == nil {
+		if err := rows.Scan(orderScanDest(&order)...); err == nil {
 			// Fetch order items
-			order.Items = []models.OrderItem{}
-			itemRows, err := h.db.Query(`SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.unit_price, p.name FROM order_items oi JOIN products p ON oi.product_id = p.id WHERE oi.order_id = $1`, order.ID)
-			if err == nil {
-				for itemRows.Next() {
-					var item models.OrderItem
-					var productName string
-					if err := itemRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &productName); err == nil {
-						item.Product = &models.Product{Name: productName}
-						order.Items = append(order.Items, item)
-					}
-				}
-				itemRows.Close()
-			}
-			if order.Items == nil {
-				order.Items = []models.OrderItem{}
-			}
+			order.Items, _ = fetchOrderItems(h.db, order.ID)
 			orders = append(orders, order)
 		}
 	}
@@ -161,28 +452,39 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]string
 // @Router /api/orders/{id} [get]
 func (h *OrderHandler) GetOrder(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var order models.Order
-	err := h.db.QueryRow(`SELECT o.id, o.user_id, u.full_name, o.status, o.total_amount, o.created_at, o.updated_at FROM orders o JOIN users u ON o.user_id = u.id WHERE o.id = $1`, id).
-		Scan(&order.ID, &order.UserID, &order.UserName, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt)
+	order, err := fetchOrder(h.db, c.Params("id"))
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
 	}
-	order.Items = []models.OrderItem{}
-	itemRows, err := h.db.Query(`SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.unit_price, p.name FROM order_items oi JOIN products p ON oi.product_id = p.id WHERE oi.order_id = $1`, id)
-	if err == nil {
-		for itemRows.Next() {
-			var item models.OrderItem
-			var productName string
-			if err := itemRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &productName); err == nil {
-				item.Product = &models.Product{Name: productName}
-				order.Items = append(order.Items, item)
-			}
-		}
-		itemRows.Close()
+	return c.Status(200).JSON(order)
+}
+
+// @Summary Look up a guest order
+// @Description Finds an order by its order number and the email used at checkout.
+// @Tags Orders
+// @Produce json
+// @Param order_number query string true "Order number"
+// @Param email query string true "Checkout email"
+// @Success 200 {object} models.Order
+// @Failure 404 {object} map[string]string
+// @Router /api/orders/lookup [get]
+func (h *OrderHandler) LookupOrder(c *fiber.Ctx) error {
+	orderNumber := strings.ToUpper(strings.TrimSpace(c.Query("order_number")))
+	email := strings.TrimSpace(c.Query("email"))
+	if orderNumber == "" || email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Order number and email are required"})
 	}
-	if order.Items == nil {
-		order.Items = []models.OrderItem{}
+	var orderID string
+	err := h.db.QueryRow(
+		`SELECT o.id FROM orders o LEFT JOIN users u ON o.user_id = u.id WHERE o.order_number = $1 AND LOWER(COALESCE(o.guest_email, u.email)) = LOWER($2)`,
+		orderNumber, email,
+	).Scan(&orderID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+	}
+	order, err := fetchOrder(h.db, orderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
 	}
 	return c.Status(200).JSON(order)
 }
@@ -193,7 +495,7 @@ func (h *OrderHandler) GetOrder(c *fiber.Ctx) error {
 // @Success 200 {array} models.Order
 // @Router /api/admin/orders [get]
 func (h *OrderHandler) GetAllOrders(c *fiber.Ctx) error {
-	rows, err := h.db.Query(`SELECT o.id, o.user_id, u.full_name, o.status, o.total_amount, o.created_at, o.updated_at FROM orders o JOIN users u ON o.user_id = u.id ORDER BY o.created_at DESC`)
+	rows, err := h.db.Query(`SELECT ` + orderColumns + ` FROM orders o LEFT JOIN users u ON o.user_id = u.id ORDER BY o.created_at DESC`)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch orders"})
 	}
@@ -201,27 +503,30 @@ func (h *OrderHandler) GetAllOrders(c *fiber.Ctx) error {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		if err := rows.Scan(&order.ID, &order.UserID, &order.UserName, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt); err == nil {
+		if err := rows.Scan(orderScanDest(&order)...); err == nil {
 			// Fetch order items
-			itemRows, err := h.db.Query(`SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.unit_price, p.name FROM order_items oi JOIN products p ON oi.product_id = p.id WHERE oi.order_id = $1`, order.ID)
-			if err == nil {
-				for itemRows.Next() {
-					var item models.OrderItem
-					var productName string
-					if err := itemRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &productName); err == nil {
-						item.Product = &models.Product{Name: productName}
-						order.Items = append(order.Items, item)
-					}
-				}
-				itemRows.Close()
-			}
+			order.Items, _ = fetchOrderItems(h.db, order.ID)
 			orders = append(orders, order)
 		}
 	}
 	return c.Status(200).JSON(orders)
 }
 
+// orderTransitions lists the statuses an order may move to from each
+// status. Fulfilment steps need a paid order, and nothing leaves cancelled
+// or refunded.
+var orderTransitions = map[string]map[string]bool{
+	"pending":    {"paid": true, "cancelled": true},
+	"paid":       {"processing": true, "shipped": true, "delivered": true, "cancelled": true, "refunded": true},
+	"processing": {"shipped": true, "delivered": true, "cancelled": true, "refunded": true},
+	"shipped":    {"delivered": true, "refunded": true},
+	"delivered":  {"refunded": true},
+	"cancelled":  {},
+	"refunded":   {},
+}
+
 // @Summary Update order status (admin)
+// @Description Moves an order along pending, paid, processing, shipped and delivered, or cancels or refunds it. Setting the current status again changes nothing.
 // @Tags Orders
 // @Accept json
 // @Produce json
@@ -229,6 +534,8 @@ func (h *OrderHandler) GetAllOrders(c *fiber.Ctx) error {
 // @Param status body models.UpdateOrderStatusRequest true "Order status"
 // @Success 200 {object} models.Order
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /api/admin/orders/{id}/status [put]
 func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
 	id := c.Params("id")
@@ -239,36 +546,102 @@ func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
 	if req.Status == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Status is required"})
 	}
-	_, err := h.db.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, id)
+	if _, ok := orderTransitions[req.Status]; !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown order status"})
+	}
+
+	tx, err := h.db.Begin()
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order status"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
 	}
+	defer tx.Rollback()
 
-	// Fetch and return the updated order
-	var order models.Order
-	err = h.db.QueryRow(
-		`SELECT o.id, o.user_id, u.full_name, o.status, o.total_amount, o.created_at, o.updated_at FROM orders o JOIN users u ON o.user_id = u.id WHERE o.id = $1`, id,
-	).Scan(&order.ID, &order.UserID, &order.UserName, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt)
+	var current string
+	err = tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Order not found"})
+	}
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated order"})
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load order"})
+	}
+	if current == req.Status {
+		order, err := fetchOrder(h.db, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+		}
+		return c.Status(200).JSON(order)
+	}
+	if !orderTransitions[current][req.Status] {
+		return c.Status(409).JSON(fiber.Map{"error": "An order cannot go from " + current + " to " + req.Status})
 	}
 
-	// Fetch order items
-	order.Items = []models.OrderItem{}
-	itemRows, err := h.db.Query(`SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.unit_price, p.name FROM order_items oi JOIN products p ON oi.product_id = p.id WHERE oi.order_id = $1`, id)
-	if err == nil {
-		for itemRows.Next() {
-			var item models.OrderItem
-			var productName string
-			if err := itemRows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &productName); err == nil {
-				item.Product = &models.Product{Name: productName}
-				order.Items = append(order.Items, item)
-			}
+	// Paying takes reserved stock for good; cancelling gives it back, or
+	// restocks what a paid order took, along with any gift card balance,
+	// store credit and loyalty points spent on the order. Refunds restock
+	// and return the tenders and points too. Both void gift cards bought
+	// with the order, and delivering a paid order earns points.
+	switch req.Status {
+	case "paid":
+		err = confirmOrderPayment(tx, id)
+	case "cancelled":
+		err = releaseReservations(tx, id)
+		if err == nil {
+			actorID, _ := c.Locals("user_id").(string)
+			err = restockOrder(tx, id, actorID, "cancellation", "Order cancelled")
+		}
+		if err == nil {
+			err = releaseCouponRedemption(tx, id)
+		}
+		if err == nil {
+			err = refundOrderTenders(tx, id)
+		}
+		if err == nil {
+			err = voidPurchasedGiftCards(tx, id)
+		}
+		if err == nil {
+			err = reverseLoyaltyPoints(tx, id)
+		}
+		if err == nil {
+			_, err = tx.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, id)
+		}
+	case "refunded":
+		actorID, _ := c.Locals("user_id").(string)
+		err = restockOrder(tx, id, actorID, "return", "Order refunded")
+		if err == nil {
+			err = refundOrderTenders(tx, id)
+		}
+		if err == nil {
+			err = voidPurchasedGiftCards(tx, id)
+		}
+		if err == nil {
+			err = reverseLoyaltyPoints(tx, id)
+		}
+		if err == nil {
+			_, err = tx.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, id)
+		}
+	case "delivered":
+		// Only paid orders get this far, so delivery always earns points
+		_, err = tx.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, id)
+		if err == nil {
+			err = awardLoyaltyPoints(tx, id, h.loyalty)
 		}
-		itemRows.Close()
+	default:
+		_, err = tx.Exec(`UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2`, req.Status, id)
 	}
-	if order.Items == nil {
-		order.Items = []models.OrderItem{}
+	if err != nil {
+		if _, ok := err.(*fiber.Error); ok {
+			return errorResponse(c, err)
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order status"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update order status"})
+	}
+
+	// Fetch and return the updated order
+	order, err := fetchOrder(h.db, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated order"})
 	}
 
 	return c.Status(200).JSON(order)
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// pricedLine is a cart or order line priced from the catalogue. Discount is
+// the total discount on the line across all quantities. Promotions are
+// applied first, then coupons, each working on what is left of the line.
+type pricedLine struct {
+	ProductID  uuid.UUID
+	VariantID  uuid.NullUUID
+	Name       string
+	CategoryID uuid.NullUUID
+	Quantity   int
+	UnitPrice  float64
+	Discount   float64
+
+	// exclusive is set once a non-stackable promotion has discounted the
+	// line, which closes it to further promotions.
+	exclusive bool
+}
+
+func (l pricedLine) total() float64 {
+	return l.UnitPrice * float64(l.Quantity)
+}
+
+// remaining is the part of the line total not yet discounted.
+func (l pricedLine) remaining() float64 {
+	return l.total() - l.Discount
+}
+
+// customerRef identifies who is buying, for per-customer limits. Either field
+// may be empty.
+type customerRef struct {
+	UserID string
+	Email  string
+}
+
+// roundMoney rounds to whole cents.
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// inCategories reports whether the line's product is in one of the listed
+// category IDs.
+func (l pricedLine) inCategories(categoryIDs []string) bool {
+	return l.CategoryID.Valid && containsFold(categoryIDs, l.CategoryID.UUID.String())
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,45 +2,189 @@ package handlers
 
 import (
 	"database/sql"
+	"ecommerce-backend/internal/media"
 	"ecommerce-backend/internal/models"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type ProductHandler struct {
-	db         *sql.DB
-	uploadPath string
+	db     *sql.DB
+	images *media.Processor
 }
 
-func NewProductHandler(db *sql.DB, uploadPath string) *ProductHandler {
-	return &ProductHandler{db: db, uploadPath: uploadPath}
+func NewProductHandler(db *sql.DB, images *media.Processor) *ProductHandler {
+	return &ProductHandler{db: db, images: images}
+}
+
+// productColumns selects a products row aliased p. Scan the result with
+// productScanDest.
+const productColumns = `p.id, COALESCE(p.sku, ''), p.name, COALESCE(p.description, ''), p.price, p.stock, ` + availableStockExpr + `,
+	COALESCE(p.category, ''), p.category_id, COALESCE(p.image_url, ''), COALESCE(p.is_active, true),
+	p.rating_average, p.rating_count, p.created_at, p.updated_at`
+
+func productScanDest(p *models.Product) []interface{} {
+	return []interface{}{&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.Stock, &p.AvailableStock,
+		&p.Category, &p.CategoryID, &p.ImageURL, &p.IsActive,
+		&p.RatingAverage, &p.RatingCount, &p.CreatedAt, &p.UpdatedAt}
+}
+
+// popularityExpr is the number of units of the product aliased p sold on
+// orders that have been paid for.
+const popularityExpr = `(SELECT COALESCE(SUM(oi.quantity), 0) FROM order_items oi JOIN orders o ON o.id = oi.order_id
+	WHERE oi.product_id = p.id AND o.status IN ('paid', 'processing', 'shipped', 'delivered'))`
+
+// productSorts maps the sort query parameter to an ORDER BY clause. Every
+// clause ends with p.id so paging is stable.
+var productSorts = map[string]string{
+	"newest":     "p.created_at DESC, p.id",
+	"price_asc":  "p.price ASC, p.id",
+	"price_desc": "p.price DESC, p.id",
+	"name":       "LOWER(p.name) ASC, p.id",
+	"popularity": popularityExpr + " DESC, p.created_at DESC, p.id",
+	"rating":     "p.rating_average DESC, p.rating_count DESC, p.id",
+}
+
+// Paging defaults and limits for product listings.
+const (
+	defaultProductLimit = 20
+	maxProductLimit     = 100
+)
+
+// productFilters builds the WHERE clause for a product listing from the
+// query string. Invalid values are reported as *fiber.Error.
+func productFilters(c *fiber.Ctx) (string, []interface{}, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	// A category includes its subcategories; plain names still match
+	// products that have not been assigned to the tree
+	if category := strings.TrimSpace(c.Query("category")); category != "" {
+		add("(p.category_id IN ("+categorySubtreeSQL+") OR (p.category_id IS NULL AND LOWER(p.category) = LOWER($%[1]d)))", category)
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"min_price", "p.price >= $%d"},
+		{"max_price", "p.price <= $%d"},
+	} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price < 0 {
+			return "", nil, fiber.NewError(400, bound.param+" must be a non-negative number")
+		}
+		add(bound.cond, price)
+	}
+	if c.QueryBool("in_stock") {
+		where = append(where, availableStockExpr+" > 0")
+	}
+	// Archived products are hidden from shoppers; admins may include them
+	if !(c.QueryBool("include_inactive") && c.Locals("role") == "admin") {
+		where = append(where, "COALESCE(p.is_active, true)")
+	}
+
+	if len(where) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args, nil
 }
 
 // @Summary Get all products
+// @Description Lists products a page at a time with optional filters.
 // @Tags Products
 // @Produce json
-// @Success 200 {array} models.Product
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param category query string false "Category ID, slug or name; includes subcategories"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param in_stock query bool false "Only products with available stock"
+// @Param include_inactive query bool false "Include archived products (admin)"
+// @Param sort query string false "newest, price_asc, price_desc, name, popularity or rating"
+// @Success 200 {object} models.ProductsResponse
+// @Failure 400 {object} map[string]string
 // @Router /api/products [get]
 func (h *ProductHandler) GetProducts(c *fiber.Ctx) error {
-	rows, err := h.db.Query(`SELECT id, name, description, price, stock, category, image_url, created_at, updated_at FROM products ORDER BY created_at DESC`)
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", defaultProductLimit)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, maxProductLimit)
+
+	sortKey := c.Query("sort", "newest")
+	orderBy, ok := productSorts[sortKey]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown sort " + sortKey})
+	}
+	where, args, err := productFilters(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	resp := models.ProductsResponse{Products: []models.Product{}, Page: page, Limit: limit}
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM products p`+where, args...).Scan(&resp.Total); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+
+	args = append(args, limit, (page-1)*limit)
+	rows, err := h.db.Query(
+		fmt.Sprintf(`SELECT %s FROM products p%s ORDER BY %s LIMIT $%d OFFSET $%d`, productColumns, where, orderBy, len(args)-1, len(args)),
+		args...,
+	)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
 	}
 	defer rows.Close()
-	var products []models.Product
+	rules, err := loadActivePromotions(h.db)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.CreatedAt, &p.UpdatedAt); err == nil {
-			products = append(products, p)
+		if err := rows.Scan(productScanDest(&p)...); err == nil {
+			setEffectivePrice(rules, &p)
+			resp.Products = append(resp.Products, p)
+		}
+	}
+	if err := attachProductImages(h.db, resp.Products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product images"})
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// fetchProduct loads a product with its effective price, image gallery,
+// options and active variants.
+func (h *ProductHandler) fetchProduct(id string) (models.Product, error) {
+	var p models.Product
+	err := h.db.QueryRow(`SELECT `+productColumns+` FROM products p WHERE p.id = $1`, id).Scan(productScanDest(&p)...)
+	if err != nil {
+		return p, err
+	}
+	if p.Images, err = productImages(h.db, id); err != nil {
+		return p, err
+	}
+	p.Options, p.Variants, err = loadVariants(h.db, p.ID, false)
+	if err != nil {
+		return p, err
+	}
+	if rules, err := loadActivePromotions(h.db); err == nil {
+		setEffectivePrice(rules, &p)
+		for i := range p.Variants {
+			setVariantEffectivePrice(rules, &p, &p.Variants[i])
 		}
 	}
-	return c.Status(200).JSON(fiber.Map{
-		"products": products,
-		"total":    len(products),
-		"page":     1,
-		"limit":    len(products),
-	})
+	return p, nil
 }
 
 // @Summary Get a product by ID
@@ -51,17 +195,14 @@ func (h *ProductHandler) GetProducts(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]string
 // @Router /api/products/{id} [get]
 func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var p models.Product
-	err := h.db.QueryRow(`SELECT id, name, description, price, stock, category, image_url, created_at, updated_at FROM products WHERE id = $1`, id).
-		Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.ImageURL, &p.CreatedAt, &p.UpdatedAt)
-	if err != nil {
+	p, err := h.fetchProduct(c.Params("id"))
+	if err != nil || (!p.IsActive && c.Locals("role") != "admin") {
 		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
 	}
-	return c.Status(201).JSON(p)
+	return c.Status(200).JSON(p)
 }
 
-// @Summary Create a new product
+// @Summary Create a new product (admin)
 // @Tags Products
 // @Accept multipart/form-data
 // @Produce json
@@ -70,16 +211,19 @@ func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 // @Failure 400 {object} map[string]string
 // @Router /api/products [post]
 func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
+	sku := strings.TrimSpace(c.FormValue("sku"))
 	name := c.FormValue("name")
 	description := c.FormValue("description")
 	price := c.FormValue("price")
 	stock := c.FormValue("stock")
 	category := c.FormValue("category")
+	categoryRef := c.FormValue("category_id")
+	if categoryRef == "" {
+		categoryRef = category
+	}
 
-	// Debug: log received form values
-	fmt.Printf("Received: name=%q, price=%q, stock=%q, category=%q\n", name, price, stock, category)
 	// Validate required fields
-	if name == "" || price == "" || stock == "" || category == "" {
+	if name == "" || price == "" || stock == "" || categoryRef == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Name, price, stock, and category are required"})
 	}
 
@@ -91,83 +235,217 @@ func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
 	if n1 != 1 || n2 != 1 || err1 != nil || err2 != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Price and stock must be valid numbers"})
 	}
+	if priceVal <= 0 || stockVal < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Name, positive price, and non-negative stock are required"})
+	}
 
 	// Handle image upload
-	imageUrl := ""
-	file, err := c.FormFile("image")
-	if err == nil && file != nil {
-		filePath := h.uploadPath + "/" + file.Filename
-		if saveErr := c.SaveFile(file, filePath); saveErr == nil {
-			imageUrl = filePath
+	var image *media.Image
+	if file, err := c.FormFile("image"); err == nil && file != nil {
+		if image, err = h.saveImage(c.UserContext(), file); err != nil {
+			return errorResponse(c, err)
 		}
 	}
 
+	categoryID, categoryName, err := resolveCategory(h.db, categoryRef, true)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Stock starts at zero and the opening stock goes through the ledger
 	var p models.Product
-	err = h.db.QueryRow(
-		`INSERT INTO products (name, description, price, stock, category, image_url) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`,
-		name, description, priceVal, stockVal, category, imageUrl,
+	err = tx.QueryRow(
+		`INSERT INTO products (sku, name, description, price, stock, category, category_id) VALUES (NULLIF($1, ''), $2, $3, $4, 0, $5, $6) RETURNING id, created_at, updated_at`,
+		sku, name, description, priceVal, categoryName, categoryID,
 	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "SKU already exists"})
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
 	}
+	actorID, _ := c.Locals("user_id").(string)
+	opening := stockMovement{productID: p.ID.String(), quantity: stockVal, movementType: "restock", reason: "Opening stock", actorID: actorID}
+	if err := recordStockMovement(tx, opening); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create product"})
+	}
+	p.Images = []models.ProductImage{}
+	if image != nil {
+		img, err := addProductImage(h.db, p.ID.String(), image, "", true)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save product image"})
+		}
+		p.Images = append(p.Images, img)
+		p.ImageURL = img.URL
+	}
 	// Populate the rest of the product struct
+	p.SKU = sku
 	p.Name = name
 	p.Description = description
 	p.Price = priceVal
 	p.Stock = stockVal
-	p.Category = category
-	p.ImageURL = imageUrl
+	p.Category = categoryName
+	if categoryID.Valid {
+		p.CategoryID = &categoryID.UUID
+	}
 	return c.Status(201).JSON(p)
 }
 
-// @Summary Update a product
+// @Summary Update a product (admin)
+// @Description Updates the fields that are sent and keeps the rest. Accepts JSON or multipart form data with an optional image file.
 // @Tags Products
-// @Accept json
+// @Accept json,mpfd
 // @Produce json
 // @Param id path string true "Product ID"
-// @Param product body models.Product true "Product data"
+// @Param product body models.ProductUpdateRequest true "Product data"
 // @Success 200 {object} models.Product
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Router /api/products/{id} [put]
+// @Router /api/products/{id} [patch]
 func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
-	var p models.Product
-	if err := c.BodyParser(&p); err != nil {
+	var req models.ProductUpdateRequest
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
+	p, err := h.fetchProduct(id)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	if req.SKU != nil {
+		p.SKU = strings.TrimSpace(*req.SKU)
+	}
+	if req.Name != nil {
+		p.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.Price != nil {
+		p.Price = *req.Price
+	}
+	if req.Stock != nil {
+		p.Stock = *req.Stock
+	}
+	categoryRef := ""
+	if req.Category != nil {
+		categoryRef = *req.Category
+	}
+	if req.CategoryID != nil {
+		categoryRef = *req.CategoryID
+	}
+	if categoryRef != "" {
+		categoryID, categoryName, err := resolveCategory(h.db, categoryRef, true)
+		if err != nil {
+			return errorResponse(c, err)
+		}
+		p.Category = categoryName
+		p.CategoryID = &categoryID.UUID
+	}
 	if p.Name == "" || p.Price <= 0 || p.Stock < 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "Name, positive price, and non-negative stock are required"})
 	}
-	_, err := h.db.Exec(
-		`UPDATE products SET name=$1, description=$2, price=$3, stock=$4, category=$5, image_url=$6, updated_at=NOW() WHERE id=$7`,
-		p.Name, p.Description, p.Price, p.Stock, p.Category, p.ImageURL, id,
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE products SET sku=NULLIF($1, ''), name=$2, description=$3, price=$4, category=$5, category_id=$6, updated_at=NOW() WHERE id=$7`,
+		p.SKU, p.Name, p.Description, p.Price, p.Category, p.CategoryID, id,
 	)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "SKU already exists"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product"})
+	}
+	// A new stock level is recorded as an adjustment by the difference
+	if req.Stock != nil {
+		actorID, _ := c.Locals("user_id").(string)
+		if err := setStockLevel(tx, id, uuid.NullUUID{}, p.Stock, actorID); err != nil {
+			if _, ok := err.(*fiber.Error); ok {
+				return errorResponse(c, err)
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update stock"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product"})
+	}
+
+	// Multipart updates may carry a new primary image
+	if file, err := c.FormFile("image"); err == nil && file != nil {
+		image, err := h.saveImage(c.UserContext(), file)
+		if err != nil {
+			return errorResponse(c, err)
+		}
+		if err := setPrimaryImage(h.db, id, image); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update product image"})
+		}
+	}
+	p, err = h.fetchProduct(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated product"})
+	}
+	return c.Status(200).JSON(p)
+}
+
+// setActive archives or restores a product and returns it.
+func (h *ProductHandler) setActive(c *fiber.Ctx, active bool) error {
+	id := c.Params("id")
+	res, err := h.db.Exec(`UPDATE products SET is_active = $1, updated_at = NOW() WHERE id = $2`, active, id)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product"})
 	}
-	// Return updated product with 201 status
-	prodResp := h.GetProduct(c)
-	c.Status(201)
-	return prodResp
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	p, err := h.fetchProduct(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated product"})
+	}
+	return c.Status(200).JSON(p)
 }
 
-// @Summary Delete a product
+// @Summary Archive a product (admin)
+// @Description Hides the product from the shop and stops new sales. Products are never deleted, so order history keeps its items; DELETE is an alias.
 // @Tags Products
 // @Produce json
 // @Param id path string true "Product ID"
-// @Success 204 {object} nil
-// @Failure 400 {object} map[string]string
+// @Success 200 {object} models.Product
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/archive [post]
 // @Router /api/products/{id} [delete]
-func (h *ProductHandler) DeleteProduct(c *fiber.Ctx) error {
-	id := c.Params("id")
-	_, err := h.db.Exec(`DELETE FROM products WHERE id = $1`, id)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete product"})
-	}
-	return c.SendStatus(204)
+func (h *ProductHandler) ArchiveProduct(c *fiber.Ctx) error {
+	return h.setActive(c, false)
+}
+
+// @Summary Unarchive a product (admin)
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} models.Product
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/unarchive [post]
+func (h *ProductHandler) UnarchiveProduct(c *fiber.Ctx) error {
+	return h.setActive(c, true)
 }
 
-// @Summary Upload product image
+// @Summary Upload product image (admin)
+// @Description Adds the image to the gallery as the primary image.
 // @Tags Products
 // @Accept multipart/form-data
 // @Produce json
@@ -175,23 +453,27 @@ func (h *ProductHandler) DeleteProduct(c *fiber.Ctx) error {
 // @Param image formData file true "Product image"
 // @Success 200 {object} models.Product
 // @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Router /api/products/{id}/image [post]
 func (h *ProductHandler) UploadProductImage(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if _, err := h.fetchProduct(id); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
 	file, err := c.FormFile("image")
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Image file is required"})
 	}
-	filePath := h.uploadPath + "/" + file.Filename
-	if err := c.SaveFile(file, filePath); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to save image"})
-	}
-	_, err = h.db.Exec(`UPDATE products SET image_url = $1, updated_at = NOW() WHERE id = $2`, filePath, id)
+	image, err := h.saveImage(c.UserContext(), file)
 	if err != nil {
+		return errorResponse(c, err)
+	}
+	if err := setPrimaryImage(h.db, id, image); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update product image"})
 	}
-	// Return updated product with 201 status
-	prodResp := h.GetProduct(c)
-	c.Status(201)
-	return prodResp
+	p, err := h.fetchProduct(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch updated product"})
+	}
+	return c.Status(200).JSON(p)
 }
@@ -0,0 +1,529 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"ecommerce-backend/internal/media"
+	"ecommerce-backend/internal/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Columns of the product CSV, in export order. Images are separated by "|".
+var productCSVColumns = []string{"sku", "name", "description", "price", "stock", "category", "active", "images"}
+
+const maxImportRows = 5000
+
+// maxImportRedirects caps how many redirects an image download follows.
+const maxImportRedirects = 3
+
+// Downloads image URLs listed in imports. Only public addresses may be
+// dialled, which also covers every redirect hop, so imports cannot reach the
+// server's own network or cloud metadata endpoints.
+var importHTTPClient = &http.Client{
+	Timeout: 20 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxImportRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// carrierGradeNAT is the shared address space of RFC 6598.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// dialPublicOnly refuses connections to loopback, private, link-local
+// (including 169.254.169.254, the metadata service), unspecified and
+// multicast addresses. It runs after DNS resolution so names pointing at
+// internal addresses are caught too.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip) {
+		return fmt.Errorf("address %s is not allowed", host)
+	}
+	return nil
+}
+
+// importRow is a parsed CSV row.
+type importRow struct {
+	report      models.ProductImportRow
+	description string
+	price       float64
+	stock       int
+	category    string
+	active      *bool
+	images      []string
+	media       []*media.Image
+}
+
+func (r *importRow) fail(format string, args ...interface{}) {
+	r.report.Errors = append(r.report.Errors, fmt.Sprintf(format, args...))
+}
+
+// readImportFile returns the CSV of an upload and, for a zip, its other files
+// by path and by base name.
+func readImportFile(data []byte) ([]byte, map[string]*zip.File, error) {
+	if http.DetectContentType(data) != "application/zip" {
+		return data, nil, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fiber.NewError(400, "Invalid zip file")
+	}
+	var csvFile *zip.File
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if strings.EqualFold(path.Ext(f.Name), ".csv") {
+			if csvFile == nil || strings.Count(f.Name, "/") < strings.Count(csvFile.Name, "/") {
+				csvFile = f
+			}
+			continue
+		}
+		files[f.Name] = f
+		if _, ok := files[path.Base(f.Name)]; !ok {
+			files[path.Base(f.Name)] = f
+		}
+	}
+	if csvFile == nil {
+		return nil, nil, fiber.NewError(400, "The zip file has no CSV")
+	}
+	rc, err := csvFile.Open()
+	if err != nil {
+		return nil, nil, fiber.NewError(400, "Invalid zip file")
+	}
+	defer rc.Close()
+	csvData, err := io.ReadAll(io.LimitReader(rc, 50<<20))
+	if err != nil {
+		return nil, nil, fiber.NewError(400, "Invalid zip file")
+	}
+	return csvData, files, nil
+}
+
+// parseImportRows validates CSV records. Problems are recorded on each row
+// rather than stopping the import so a dry run can list them all.
+func parseImportRows(data []byte, zipFiles map[string]*zip.File) ([]*importRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fiber.NewError(400, "The CSV file is empty or invalid")
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "price", "stock", "category"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fiber.NewError(400, "Missing column "+required)
+		}
+	}
+
+	var rows []*importRow
+	seen := map[string]int{}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) == maxImportRows {
+			return nil, fiber.NewError(400, fmt.Sprintf("Imports are limited to %d rows", maxImportRows))
+		}
+		row := &importRow{report: models.ProductImportRow{Row: line}}
+		rows = append(rows, row)
+		if err != nil {
+			row.fail("Invalid CSV: %v", err)
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row.report.SKU = field("sku")
+		row.report.Name = field("name")
+		row.description = field("description")
+		row.category = field("category")
+		if row.report.Name == "" {
+			row.fail("name is required")
+		}
+		if row.category == "" {
+			row.fail("category is required")
+		}
+		if row.price, err = strconv.ParseFloat(field("price"), 64); err != nil || row.price <= 0 {
+			row.fail("price must be a positive number")
+		}
+		if row.stock, err = strconv.Atoi(field("stock")); err != nil || row.stock < 0 {
+			row.fail("stock must be a non-negative whole number")
+		}
+		if v := field("active"); v != "" {
+			active, err := strconv.ParseBool(v)
+			if err != nil {
+				row.fail("active must be true or false")
+			}
+			row.active = &active
+		}
+		for _, img := range strings.Split(field("images"), "|") {
+			if img = strings.TrimSpace(img); img == "" {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(img, "http://"), strings.HasPrefix(img, "https://"), strings.HasPrefix(img, "/"):
+			case zipFiles == nil:
+				row.fail("image %q is not a URL; upload a zip to include image files", img)
+			case zipFiles[img] == nil:
+				row.fail("image %q is not in the zip file", img)
+			}
+			row.images = append(row.images, img)
+		}
+
+		key := "name:" + strings.ToLower(row.report.Name)
+		if row.report.SKU != "" {
+			key = "sku:" + strings.ToLower(row.report.SKU)
+		}
+		if first, ok := seen[key]; ok {
+			row.fail("duplicates row %d", first)
+		} else {
+			seen[key] = line
+		}
+	}
+	if len(rows) == 0 {
+		return nil, fiber.NewError(400, "The CSV file has no rows")
+	}
+	return rows, nil
+}
+
+// loadImportImages fetches or unpacks a row's images and stores their
+// renditions. Images already in the store (paths such as /uploads/...) are
+// resolved when the row is saved.
+func (h *ProductHandler) loadImportImages(ctx context.Context, row *importRow, zipFiles map[string]*zip.File) {
+	for _, ref := range row.images {
+		var (
+			img *media.Image
+			err error
+		)
+		switch {
+		case strings.HasPrefix(ref, "/"):
+			img = &media.Image{URL: ref}
+		case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+			img, err = h.downloadImage(ctx, ref)
+		default:
+			var rc io.ReadCloser
+			if rc, err = zipFiles[ref].Open(); err == nil {
+				img, err = h.images.Process(ctx, rc)
+				rc.Close()
+			}
+		}
+		switch {
+		case errors.Is(err, media.ErrTooLarge):
+			row.fail("image %q is too large", ref)
+		case errors.Is(err, media.ErrUnsupported):
+			row.fail("%q is not a JPEG, PNG, GIF or WebP image", ref)
+		case err != nil:
+			row.fail("image %q could not be loaded: %v", ref, err)
+		default:
+			row.media = append(row.media, img)
+		}
+	}
+}
+
+func (h *ProductHandler) downloadImage(ctx context.Context, rawURL string) (*media.Image, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := importHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned %s", resp.Status)
+	}
+	return h.images.Process(ctx, resp.Body)
+}
+
+// saveImportRow creates or updates the product of one row. Its category is
+// created if needed. When the row lists images they replace the gallery.
+// Stock changes are recorded in the ledger as made by actorID.
+func saveImportRow(tx *sql.Tx, row *importRow, actorID string) error {
+	var ids []uuid.UUID
+	query, key := `SELECT id FROM products WHERE sku = $1`, row.report.SKU
+	if key == "" {
+		query, key = `SELECT id FROM products WHERE LOWER(name) = LOWER($1) LIMIT 2`, row.report.Name
+	}
+	found, err := tx.Query(query, key)
+	if err != nil {
+		return err
+	}
+	for found.Next() {
+		var id uuid.UUID
+		if err := found.Scan(&id); err != nil {
+			found.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	found.Close()
+	if err := found.Err(); err != nil {
+		return err
+	}
+	if len(ids) > 1 {
+		return fmt.Errorf("several products are named %q; add a sku to tell them apart", row.report.Name)
+	}
+	var id uuid.UUID
+	exists := len(ids) == 1
+	if exists {
+		id = ids[0]
+	}
+
+	categoryID, categoryName, err := resolveCategory(tx, row.category, true)
+	if err != nil {
+		return errors.New(errorMessage(err))
+	}
+	if exists {
+		row.report.Action = "update"
+		_, err = tx.Exec(
+			`UPDATE products SET sku = NULLIF($1, ''), name = $2, description = $3, price = $4, category = $5, category_id = $6,
+				is_active = COALESCE($7, is_active), updated_at = NOW()
+			WHERE id = $8`,
+			row.report.SKU, row.report.Name, row.description, row.price, categoryName, categoryID, row.active, id,
+		)
+	} else {
+		row.report.Action = "create"
+		err = tx.QueryRow(
+			`INSERT INTO products (sku, name, description, price, stock, category, category_id, is_active)
+			VALUES (NULLIF($1, ''), $2, $3, $4, 0, $5, $6, COALESCE($7, true)) RETURNING id`,
+			row.report.SKU, row.report.Name, row.description, row.price, categoryName, categoryID, row.active,
+		).Scan(&id)
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return fmt.Errorf("sku %q belongs to another product", row.report.SKU)
+	}
+	if err != nil {
+		return err
+	}
+	row.report.ProductID = &id
+	if err := setStockLevel(tx, id.String(), uuid.NullUUID{}, row.stock, actorID); err != nil {
+		return err
+	}
+
+	if len(row.media) == 0 {
+		return nil
+	}
+	// Images already in the store keep the renditions recorded for them
+	for _, img := range row.media {
+		if img.Renditions != nil {
+			continue
+		}
+		var renditions []byte
+		err := tx.QueryRow(`SELECT renditions FROM product_images WHERE url = $1 LIMIT 1`, img.URL).Scan(&renditions)
+		if err == nil {
+			err = json.Unmarshal(renditions, &img.Renditions)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if img.Renditions == nil {
+			img.Renditions = map[string]string{}
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM product_images WHERE product_id = $1`, id); err != nil {
+		return err
+	}
+	for i, img := range row.media {
+		if _, err := addProductImage(tx, id.String(), img, "", i == 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// @Summary Import products from CSV (admin)
+// @Description Upserts products by sku, or by name when sku is empty. Columns: sku, name, description, price, stock, category, active, images (URLs or zip paths separated by "|"). Upload a zip holding the CSV and image files to bundle images. Nothing is saved unless every row is valid; dry_run=true only reports what would happen.
+// @Tags Products
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or zip file"
+// @Param dry_run query bool false "Validate without saving"
+// @Success 200 {object} models.ProductImportResult
+// @Failure 400 {object} map[string]string
+// @Failure 422 {object} models.ProductImportResult
+// @Router /api/admin/products/import [post]
+func (h *ProductHandler) ImportProducts(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dry_run") || c.FormValue("dry_run") == "true"
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "A CSV or zip file is required"})
+	}
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read file"})
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Failed to read file"})
+	}
+	csvData, zipFiles, err := readImportFile(data)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	rows, err := parseImportRows(csvData, zipFiles)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	valid := func() bool {
+		for _, row := range rows {
+			if len(row.report.Errors) > 0 {
+				return false
+			}
+		}
+		return true
+	}
+	// Images are only stored for real imports of valid files
+	if !dryRun && valid() {
+		for _, row := range rows {
+			h.loadImportImages(c.UserContext(), row, zipFiles)
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	actorID, _ := c.Locals("user_id").(string)
+	for _, row := range rows {
+		if len(row.report.Errors) > 0 {
+			continue
+		}
+		// A failed row must not abort the rest of the transaction
+		if _, err := tx.Exec(`SAVEPOINT import_row`); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to import products"})
+		}
+		if err := saveImportRow(tx, row, actorID); err != nil {
+			row.fail("%s", err.Error())
+			if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT import_row`); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to import products"})
+			}
+		}
+	}
+
+	result := models.ProductImportResult{DryRun: dryRun, Rows: make([]models.ProductImportRow, len(rows))}
+	for i, row := range rows {
+		result.Rows[i] = row.report
+		switch {
+		case len(row.report.Errors) > 0:
+			result.Failed++
+		case row.report.Action == "create":
+			result.Created++
+		default:
+			result.Updated++
+		}
+	}
+	if result.Failed > 0 {
+		status := 422
+		if dryRun {
+			status = 200
+		}
+		return c.Status(status).JSON(result)
+	}
+	if !dryRun {
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to import products"})
+		}
+	}
+	return c.Status(200).JSON(result)
+}
+
+// @Summary Export products as CSV (admin)
+// @Description Exports every product, including inactive ones, in the format accepted by the import.
+// @Tags Products
+// @Produce text/csv
+// @Success 200 {string} string "CSV file"
+// @Router /api/admin/products/export [get]
+func (h *ProductHandler) ExportProducts(c *fiber.Ctx) error {
+	rows, err := h.db.Query(`SELECT ` + productColumns + ` FROM products p ORDER BY p.name, p.id`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+	}
+	defer rows.Close()
+	var products []models.Product
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(productScanDest(&p)...); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch products"})
+		}
+		products = append(products, p)
+	}
+	if err := attachProductImages(h.db, products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product images"})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(productCSVColumns)
+	for _, p := range products {
+		urls := make([]string, len(p.Images))
+		for i, img := range p.Images {
+			urls[i] = img.URL
+		}
+		w.Write([]string{
+			p.SKU, p.Name, p.Description,
+			strconv.FormatFloat(p.Price, 'f', -1, 64), strconv.Itoa(p.Stock),
+			p.Category, strconv.FormatBool(p.IsActive), strings.Join(urls, "|"),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to write CSV"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="products-%s.csv"`, time.Now().Format("20060102")))
+	return c.Status(200).Send(buf.Bytes())
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type PromotionHandler struct {
+	db *sql.DB
+}
+
+func NewPromotionHandler(db *sql.DB) *PromotionHandler {
+	return &PromotionHandler{db: db}
+}
+
+// promotionRule is an active promotion as used by the pricing engine.
+type promotionRule struct {
+	ID         uuid.UUID
+	Name       string
+	Type       string
+	Value      float64
+	ProductIDs []string
+	// Categories holds the IDs of the targeted categories and all of their
+	// subcategories.
+	Categories []string
+	Buy, Get   int
+	Stackable  bool
+}
+
+// matches reports whether the rule targets the line's product or category.
+// Rules without targets cover everything.
+func (r promotionRule) matches(l pricedLine) bool {
+	if len(r.ProductIDs) == 0 && len(r.Categories) == 0 {
+		return true
+	}
+	return containsFold(r.ProductIDs, l.ProductID.String()) || l.inCategories(r.Categories)
+}
+
+// loadActivePromotions returns promotions running now, highest priority first.
+func loadActivePromotions(q querier) ([]promotionRule, error) {
+	rows, err := q.Query(
+		`SELECT id, name, promotion_type, value, product_ids, ` + fmt.Sprintf(categoryArraySubtreeSQL, "categories") + `,
+			COALESCE(buy_quantity, 0), COALESCE(get_quantity, 0), stackable
+		FROM promotions
+		WHERE is_active = true AND starts_at <= NOW() AND ends_at > NOW()
+		ORDER BY priority DESC, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []promotionRule
+	for rows.Next() {
+		var r promotionRule
+		if err := rows.Scan(&r.ID, &r.Name, &r.Type, &r.Value, pq.Array(&r.ProductIDs), pq.Array(&r.Categories), &r.Buy, &r.Get, &r.Stackable); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// applyPromotionRules discounts lines with every rule in order and returns
+// the promotions that took effect.
+//
+// Stacking policy: a non-stackable promotion only touches lines that no
+// earlier promotion has discounted and, once applied, closes those lines to
+// later promotions. Stackable promotions apply on top of earlier stackable
+// ones. Coupons are applied afterwards to whatever is left.
+func applyPromotionRules(rules []promotionRule, lines []pricedLine) []models.AppliedPromotion {
+	applied := []models.AppliedPromotion{}
+	for _, r := range rules {
+		var idx []int
+		for i, l := range lines {
+			if !r.matches(l) || l.exclusive || l.remaining() <= 0 {
+				continue
+			}
+			if !r.Stackable && l.Discount > 0 {
+				continue
+			}
+			if r.Type == "bundle" && !containsFold(r.ProductIDs, l.ProductID.String()) {
+				continue
+			}
+			idx = append(idx, i)
+		}
+		if len(idx) == 0 {
+			continue
+		}
+
+		discounts := map[int]float64{}
+		switch r.Type {
+		case "percentage":
+			for _, i := range idx {
+				discounts[i] = lines[i].remaining() * r.Value / 100
+			}
+		case "fixed":
+			// Amount off each unit
+			for _, i := range idx {
+				discounts[i] = r.Value * float64(lines[i].Quantity)
+			}
+		case "buy_x_get_y":
+			discounts = buyXGetYDiscounts(r, lines, idx)
+		case "bundle":
+			discounts = bundleDiscounts(r, lines, idx)
+		}
+
+		var total float64
+		for i, d := range discounts {
+			d = roundMoney(d)
+			if d > lines[i].remaining() {
+				d = roundMoney(lines[i].remaining())
+			}
+			if d <= 0 {
+				continue
+			}
+			lines[i].Discount = roundMoney(lines[i].Discount + d)
+			if !r.Stackable {
+				lines[i].exclusive = true
+			}
+			total += d
+		}
+		if total > 0 {
+			applied = append(applied, models.AppliedPromotion{ID: r.ID, Name: r.Name, Discount: roundMoney(total)})
+		}
+	}
+	return applied
+}
+
+// buyXGetYDiscounts gives Value percent off the cheapest Get units in every
+// group of Buy+Get matching units.
+func buyXGetYDiscounts(r promotionRule, lines []pricedLine, idx []int) map[int]float64 {
+	type unit struct {
+		line  int
+		price float64
+	}
+	var units []unit
+	for _, i := range idx {
+		price := lines[i].remaining() / float64(lines[i].Quantity)
+		for q := 0; q < lines[i].Quantity; q++ {
+			units = append(units, unit{line: i, price: price})
+		}
+	}
+	sort.SliceStable(units, func(a, b int) bool { return units[a].price < units[b].price })
+
+	discounts := map[int]float64{}
+	if r.Buy+r.Get <= 0 {
+		return discounts
+	}
+	free := len(units) / (r.Buy + r.Get) * r.Get
+	for _, u := range units[:free] {
+		discounts[u.line] += u.price * r.Value / 100
+	}
+	return discounts
+}
+
+// bundleDiscounts gives Value percent off each complete set of the bundled
+// products.
+func bundleDiscounts(r promotionRule, lines []pricedLine, idx []int) map[int]float64 {
+	have := map[string]int{}
+	for _, i := range idx {
+		have[lines[i].ProductID.String()] += lines[i].Quantity
+	}
+	sets := -1
+	for _, id := range r.ProductIDs {
+		if sets < 0 || have[id] < sets {
+			sets = have[id]
+		}
+	}
+	discounts := map[int]float64{}
+	if sets <= 0 {
+		return discounts
+	}
+	left := map[string]int{}
+	for _, id := range r.ProductIDs {
+		left[id] = sets
+	}
+	for _, i := range idx {
+		id := lines[i].ProductID.String()
+		n := lines[i].Quantity
+		if left[id] < n {
+			n = left[id]
+		}
+		left[id] -= n
+		discounts[i] = lines[i].remaining() / float64(lines[i].Quantity) * float64(n) * r.Value / 100
+	}
+	return discounts
+}
+
+// applyPromotions loads the running promotions and applies them to lines.
+func applyPromotions(q querier, lines []pricedLine) ([]models.AppliedPromotion, error) {
+	rules, err := loadActivePromotions(q)
+	if err != nil {
+		return nil, err
+	}
+	return applyPromotionRules(rules, lines), nil
+}
+
+// setEffectivePrice fills in the sale price of a single unit of p under the
+// given promotions.
+func setEffectivePrice(rules []promotionRule, p *models.Product) {
+	lines := []pricedLine{{ProductID: p.ID, Name: p.Name, CategoryID: nullUUID(p.CategoryID), Quantity: 1, UnitPrice: p.Price}}
+	p.Promotions = applyPromotionRules(rules, lines)
+	p.EffectivePrice = roundMoney(p.Price - lines[0].Discount)
+}
+
+// setVariantEffectivePrice works out a variant's price after automatic
+// promotions for its product.
+func setVariantEffectivePrice(rules []promotionRule, p *models.Product, v *models.ProductVariant) {
+	price := p.Price
+	if v.Price != nil {
+		price = *v.Price
+	}
+	lines := []pricedLine{{ProductID: p.ID, Name: p.Name, CategoryID: nullUUID(p.CategoryID), Quantity: 1, UnitPrice: price}}
+	applyPromotionRules(rules, lines)
+	v.EffectivePrice = roundMoney(price - lines[0].Discount)
+}
+
+const promotionColumns = `id, name, COALESCE(description, ''), promotion_type, value, product_ids, categories, buy_quantity, get_quantity,
+	priority, stackable, starts_at, ends_at, is_active, created_at, updated_at`
+
+func scanPromotion(row interface{ Scan(...interface{}) error }) (models.Promotion, error) {
+	var pr models.Promotion
+	var productIDs []string
+	err := row.Scan(&pr.ID, &pr.Name, &pr.Description, &pr.PromotionType, &pr.Value, pq.Array(&productIDs), pq.Array(&pr.Categories),
+		&pr.BuyQuantity, &pr.GetQuantity, &pr.Priority, &pr.Stackable, &pr.StartsAt, &pr.EndsAt, &pr.IsActive, &pr.CreatedAt, &pr.UpdatedAt)
+	if err != nil {
+		return pr, err
+	}
+	pr.ProductIDs = make([]uuid.UUID, 0, len(productIDs))
+	for _, id := range productIDs {
+		if pid, err := uuid.Parse(id); err == nil {
+			pr.ProductIDs = append(pr.ProductIDs, pid)
+		}
+	}
+	if pr.Categories == nil {
+		pr.Categories = []string{}
+	}
+	return pr, nil
+}
+
+func validatePromotionRequest(req *models.PromotionRequest) error {
+	if req.Name == "" {
+		return fiber.NewError(400, "Name is required")
+	}
+	switch req.PromotionType {
+	case "percentage", "fixed":
+	case "buy_x_get_y":
+		if req.BuyQuantity == nil || req.GetQuantity == nil || *req.BuyQuantity <= 0 || *req.GetQuantity <= 0 {
+			return fiber.NewError(400, "Buy and get quantities are required for buy_x_get_y promotions")
+		}
+	case "bundle":
+		if len(req.ProductIDs) < 2 {
+			return fiber.NewError(400, "Bundles need at least two products")
+		}
+	default:
+		return fiber.NewError(400, "Promotion type must be percentage, fixed, buy_x_get_y or bundle")
+	}
+	if req.Value <= 0 || (req.PromotionType != "fixed" && req.Value > 100) {
+		return fiber.NewError(400, "Value must be positive and at most 100 for percentages")
+	}
+	if req.StartsAt.IsZero() || req.EndsAt.IsZero() || !req.EndsAt.After(req.StartsAt) {
+		return fiber.NewError(400, "Start and end times are required and end must be after start")
+	}
+	if req.ProductIDs == nil {
+		req.ProductIDs = []uuid.UUID{}
+	}
+	if req.Categories == nil {
+		req.Categories = []string{}
+	}
+	return nil
+}
+
+// @Summary List promotions (admin)
+// @Tags Promotions
+// @Produce json
+// @Success 200 {array} models.Promotion
+// @Security BearerAuth
+// @Router /api/admin/promotions [get]
+func (h *PromotionHandler) GetPromotions(c *fiber.Ctx) error {
+	rows, err := h.db.Query(`SELECT ` + promotionColumns + ` FROM promotions ORDER BY priority DESC, starts_at DESC`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	defer rows.Close()
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		pr, err := scanPromotion(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan promotion"})
+		}
+		promotions = append(promotions, pr)
+	}
+	return c.Status(200).JSON(promotions)
+}
+
+// @Summary Get a promotion (admin)
+// @Tags Promotions
+// @Produce json
+// @Param id path string true "Promotion ID"
+// @Success 200 {object} models.Promotion
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/promotions/{id} [get]
+func (h *PromotionHandler) GetPromotion(c *fiber.Ctx) error {
+	pr, err := scanPromotion(h.db.QueryRow(`SELECT `+promotionColumns+` FROM promotions WHERE id = $1`, c.Params("id")))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+	}
+	return c.Status(200).JSON(pr)
+}
+
+// @Summary Create a promotion (admin)
+// @Description Types: percentage (percent off), fixed (amount off each unit), buy_x_get_y (value percent off the cheapest get_quantity units per buy_quantity+get_quantity), bundle (value percent off each complete set of product_ids). categories take category IDs, slugs or names; they are stored as IDs and also cover subcategories.
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Param promotion body models.PromotionRequest true "Promotion data"
+// @Success 201 {object} models.Promotion
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/promotions [post]
+func (h *PromotionHandler) CreatePromotion(c *fiber.Ctx) error {
+	var req models.PromotionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validatePromotionRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+	categories, err := resolveCategoryRefs(h.db, req.Categories)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	pr, err := scanPromotion(h.db.QueryRow(
+		`INSERT INTO promotions (name, description, promotion_type, value, product_ids, categories, buy_quantity, get_quantity,
+			priority, stackable, starts_at, ends_at, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+promotionColumns,
+		req.Name, req.Description, req.PromotionType, req.Value, pq.Array(uuidStrings(req.ProductIDs)), pq.Array(categories),
+		req.BuyQuantity, req.GetQuantity, req.Priority, req.Stackable, req.StartsAt, req.EndsAt, isActive,
+	))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create promotion"})
+	}
+	return c.Status(201).JSON(pr)
+}
+
+// @Summary Update a promotion (admin)
+// @Tags Promotions
+// @Accept json
+// @Produce json
+// @Param id path string true "Promotion ID"
+// @Param promotion body models.PromotionRequest true "Promotion data"
+// @Success 200 {object} models.Promotion
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/promotions/{id} [put]
+func (h *PromotionHandler) UpdatePromotion(c *fiber.Ctx) error {
+	var req models.PromotionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validatePromotionRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+	categories, err := resolveCategoryRefs(h.db, req.Categories)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	isActive := req.IsActive == nil || *req.IsActive
+	pr, err := scanPromotion(h.db.QueryRow(
+		`UPDATE promotions SET name = $1, description = $2, promotion_type = $3, value = $4, product_ids = $5, categories = $6,
+			buy_quantity = $7, get_quantity = $8, priority = $9, stackable = $10, starts_at = $11, ends_at = $12, is_active = $13,
+			updated_at = NOW()
+		WHERE id = $14
+		RETURNING `+promotionColumns,
+		req.Name, req.Description, req.PromotionType, req.Value, pq.Array(uuidStrings(req.ProductIDs)), pq.Array(categories),
+		req.BuyQuantity, req.GetQuantity, req.Priority, req.Stackable, req.StartsAt, req.EndsAt, isActive, c.Params("id"),
+	))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update promotion"})
+	}
+	return c.Status(200).JSON(pr)
+}
+
+// @Summary Deactivate a promotion (admin)
+// @Tags Promotions
+// @Param id path string true "Promotion ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/promotions/{id} [delete]
+func (h *PromotionHandler) DeletePromotion(c *fiber.Ctx) error {
+	res, err := h.db.Exec(`UPDATE promotions SET is_active = false, updated_at = NOW() WHERE id = $1`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate promotion"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Promotion not found"})
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"ecommerce-backend/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+// Size limits for recommendation lists.
+const (
+	defaultRecommendationLimit = 8
+	maxRecommendationLimit     = 20
+)
+
+// @Summary Product recommendations
+// @Description Products frequently bought together with this one, topped up with similar products from the same or sibling categories.
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param limit query int false "Number of products (default 8, max 20)"
+// @Success 200 {array} models.Recommendation
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/recommendations [get]
+func (h *ProductHandler) GetRecommendations(c *fiber.Ctx) error {
+	id := c.Params("id")
+	limit := c.QueryInt("limit", defaultRecommendationLimit)
+	if limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Limit must be positive"})
+	}
+	limit = min(limit, maxRecommendationLimit)
+
+	var exists bool
+	err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND (COALESCE(is_active, true) OR $2))`, id, c.Locals("role") == "admin").
+		Scan(&exists)
+	if err != nil || !exists {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	recs := []models.Recommendation{}
+	rows, err := h.db.Query(
+		`SELECT `+productColumns+`, cp.orders_count FROM product_co_purchases cp JOIN products p ON p.id = cp.related_id
+		WHERE cp.product_id = $1 AND COALESCE(p.is_active, true)
+		ORDER BY cp.orders_count DESC, p.rating_average DESC, p.id LIMIT $2`,
+		id, limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch recommendations"})
+	}
+	for rows.Next() {
+		r := models.Recommendation{Reason: "bought_together"}
+		if err := rows.Scan(append(productScanDest(&r.Product), &r.BoughtTogether)...); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch recommendations"})
+		}
+		recs = append(recs, r)
+	}
+	rows.Close()
+
+	// New products have no purchase history yet; suggest similar ones
+	if len(recs) < limit {
+		seen := []string{id}
+		for _, r := range recs {
+			seen = append(seen, r.ID.String())
+		}
+		rows, err := h.db.Query(
+			`SELECT `+productColumns+` FROM products p, (SELECT category_id, category FROM products WHERE id = $1) t
+			WHERE p.id <> ALL($2) AND COALESCE(p.is_active, true)
+				AND (p.category_id = t.category_id
+					OR p.category_id IN (SELECT c.id FROM categories c JOIN categories own ON own.id = t.category_id WHERE c.parent_id = own.parent_id)
+					OR (t.category_id IS NULL AND LOWER(p.category) = LOWER(t.category)))
+			ORDER BY (p.category_id IS NOT DISTINCT FROM t.category_id) DESC, (`+availableStockExpr+` > 0) DESC,
+				p.rating_average DESC, p.created_at DESC, p.id
+			LIMIT $3`,
+			id, pq.Array(seen), limit-len(recs),
+		)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch recommendations"})
+		}
+		defer rows.Close()
+		for rows.Next() {
+			r := models.Recommendation{Reason: "similar"}
+			if err := rows.Scan(productScanDest(&r.Product)...); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch recommendations"})
+			}
+			recs = append(recs, r)
+		}
+	}
+
+	products := make([]models.Product, len(recs))
+	for i := range recs {
+		products[i] = recs[i].Product
+	}
+	if err := attachProductImages(h.db, products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product images"})
+	}
+	rules, err := loadActivePromotions(h.db)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	for i := range recs {
+		recs[i].Product = products[i]
+		setEffectivePrice(rules, &recs[i].Product)
+	}
+	c.Set("Cache-Control", "public, max-age=300")
+	return c.Status(200).JSON(recs)
+}
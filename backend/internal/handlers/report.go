@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReportHandler struct {
+	db *sql.DB
+}
+
+func NewReportHandler(db *sql.DB) *ReportHandler {
+	return &ReportHandler{db: db}
+}
+
+// reportRange reads the from/to query parameters (YYYY-MM-DD), defaulting to
+// the last 30 days. The returned to is exclusive.
+func reportRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fiber.NewError(400, "Invalid from date, expected YYYY-MM-DD")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fiber.NewError(400, "Invalid to date, expected YYYY-MM-DD")
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// @Summary Abandoned cart recovery report (admin)
+// @Description Reminders sent in the period and the carts and revenue they recovered.
+// @Tags Reports
+// @Produce json
+// @Param from query string false "Start date (YYYY-MM-DD), defaults to 30 days ago"
+// @Param to query string false "End date (YYYY-MM-DD), inclusive"
+// @Success 200 {object} models.AbandonedCartReport
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/reports/abandoned-carts [get]
+func (h *ReportHandler) AbandonedCarts(c *fiber.Ctx) error {
+	from, to, err := reportRange(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	report := models.AbandonedCartReport{From: from, To: to}
+	err = h.db.QueryRow(
+		`SELECT COUNT(*), COUNT(o.id), COALESCE(SUM(r.cart_value), 0), COALESCE(SUM(o.total_amount), 0)
+		FROM cart_reminders r
+		LEFT JOIN orders o ON o.id = r.recovered_order_id AND o.status <> 'cancelled'
+		WHERE r.sent_at >= $1 AND r.sent_at < $2`,
+		from, to,
+	).Scan(&report.RemindersSent, &report.CartsRecovered, &report.RemindedValue, &report.RecoveredRevenue)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build report"})
+	}
+	if report.RemindersSent > 0 {
+		report.RecoveryRate = float64(report.CartsRecovered) / float64(report.RemindersSent)
+	}
+	return c.Status(200).JSON(report)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// variantAvailableStockExpr is the stock of the product_variants row aliased
+// v that is not held by an unexpired reservation.
+const variantAvailableStockExpr = `(v.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r WHERE r.variant_id = v.id AND r.status = 'active' AND r.expires_at > NOW()), 0))`
+
+// hasVariantsExpr is true when the products row aliased p is sold as variants.
+const hasVariantsExpr = `EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND v.is_active)`
+
+// availableStockExpr is the stock of the products row aliased p that is not
+// held by an unexpired reservation. Products sold as variants have the
+// combined availability of their active variants.
+const availableStockExpr = `(CASE WHEN ` + hasVariantsExpr + `
+	THEN (SELECT COALESCE(SUM(GREATEST(` + variantAvailableStockExpr + `, 0)), 0) FROM product_variants v WHERE v.product_id = p.id AND v.is_active)
+	ELSE p.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r WHERE r.product_id = p.id AND r.variant_id IS NULL AND r.status = 'active' AND r.expires_at > NOW()), 0)
+	END)`
+
+// variantLabelExpr names the product_variants row aliased v by its option
+// values in option order, e.g. "42 / Black".
+const variantLabelExpr = `COALESCE((SELECT string_agg(v.options->>ot.name, ' / ' ORDER BY ot.position) FROM product_option_types ot WHERE ot.product_id = v.product_id), '')`
+
+// reserveStock holds quantity of a product, or of one of its variants, for an
+// order until ttl elapses. The caller must hold a lock on the products row
+// (and variant row) and have checked availability.
+func reserveStock(tx *sql.Tx, orderID string, productID uuid.UUID, variantID uuid.NullUUID, quantity int, ttl time.Duration) error {
+	_, err := tx.Exec(
+		`INSERT INTO stock_reservations (product_id, variant_id, order_id, quantity, expires_at) VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5))`,
+		productID, variantID, orderID, quantity, ttl.Seconds(),
+	)
+	return err
+}
+
+// confirmOrderPayment marks a pending order paid and turns its reservations
+// into real stock decrements at the locations the order is allocated to.
+// Items whose reservation has expired are taken from unreserved stock if
+// there is enough. Orders that are already past pending are left alone so
+// payment callbacks can be retried safely.
+func confirmOrderPayment(tx *sql.Tx, orderID string) error {
+	var status string
+	err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return fiber.NewError(404, "Order not found")
+	}
+	if err != nil {
+		return fiber.NewError(500, "Failed to load order")
+	}
+	if status == "cancelled" {
+		return fiber.NewError(409, "Order has been cancelled")
+	}
+	if status != "pending" {
+		return nil
+	}
+
+	rows, err := tx.Query(
+		`SELECT product_id, variant_id, SUM(quantity) FROM order_items WHERE order_id = $1
+		GROUP BY product_id, variant_id ORDER BY product_id, variant_id NULLS FIRST`, orderID)
+	if err != nil {
+		return fiber.NewError(500, "Failed to load order items")
+	}
+	var needs []stockNeed
+	for rows.Next() {
+		var n stockNeed
+		if err := rows.Scan(&n.productID, &n.variantID, &n.quantity); err != nil {
+			rows.Close()
+			return fiber.NewError(500, "Failed to load order items")
+		}
+		needs = append(needs, n)
+	}
+	rows.Close()
+
+	for _, n := range needs {
+		if n.variantID.Valid {
+			if err := checkVariantStock(tx, orderID, n.productID, n.variantID.UUID, n.quantity); err != nil {
+				return err
+			}
+			continue
+		}
+		var name string
+		var available, held int
+		err := tx.QueryRow(
+			`SELECT p.name, `+availableStockExpr+`,
+				COALESCE((SELECT SUM(quantity) FROM stock_reservations WHERE order_id = $2 AND product_id = p.id AND variant_id IS NULL AND status = 'active' AND expires_at > NOW()), 0)
+			FROM products p WHERE p.id = $1 FOR UPDATE`,
+			n.productID, orderID,
+		).Scan(&name, &available, &held)
+		if err != nil {
+			return fiber.NewError(500, "Failed to load product")
+		}
+		// Our own hold counts towards what we may take
+		if available+held < n.quantity {
+			return fiber.NewError(409, "Insufficient stock for "+name)
+		}
+	}
+	if err := fulfillOrder(tx, orderID, needs); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE stock_reservations SET status = 'converted', updated_at = NOW() WHERE order_id = $1 AND status <> 'converted'`, orderID); err != nil {
+		return fiber.NewError(500, "Failed to convert reservations")
+	}
+	if _, err := tx.Exec(`UPDATE orders SET status = 'paid', updated_at = NOW() WHERE id = $1`, orderID); err != nil {
+		return fiber.NewError(500, "Failed to update order status")
+	}
+	if err := activatePurchasedGiftCards(tx, orderID); err != nil {
+		return fiber.NewError(500, "Failed to activate gift cards")
+	}
+	return nil
+}
+
+// checkVariantStock locks a variant and checks a paid order may take
+// quantity of it, counting the order's own hold towards what it may take.
+// The products row is locked before the variant to keep the lock order used
+// by placeOrder.
+func checkVariantStock(tx *sql.Tx, orderID string, productID, variantID uuid.UUID, quantity int) error {
+	var name string
+	if err := tx.QueryRow(`SELECT name FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&name); err != nil {
+		return fiber.NewError(500, "Failed to load product")
+	}
+	var label string
+	var available, held int
+	err := tx.QueryRow(
+		`SELECT `+variantLabelExpr+`, `+variantAvailableStockExpr+`,
+			COALESCE((SELECT SUM(quantity) FROM stock_reservations WHERE order_id = $2 AND variant_id = v.id AND status = 'active' AND expires_at > NOW()), 0)
+		FROM product_variants v WHERE v.id = $1 FOR UPDATE`,
+		variantID, orderID,
+	).Scan(&label, &available, &held)
+	if err != nil {
+		return fiber.NewError(500, "Failed to load product variant")
+	}
+	if available+held < quantity {
+		return fiber.NewError(409, "Insufficient stock for "+variantName(name, label))
+	}
+	return nil
+}
+
+// variantName is a product name qualified by a variant label.
+func variantName(product, label string) string {
+	if label == "" {
+		return product
+	}
+	return product + " (" + label + ")"
+}
+
+// releaseReservations frees any stock still held for an order.
+func releaseReservations(tx *sql.Tx, orderID string) error {
+	_, err := tx.Exec(`UPDATE stock_reservations SET status = 'released', updated_at = NOW() WHERE order_id = $1 AND status = 'active'`, orderID)
+	return err
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+type ReviewHandler struct {
+	db *sql.DB
+}
+
+func NewReviewHandler(db *sql.DB) *ReviewHandler {
+	return &ReviewHandler{db: db}
+}
+
+// reviewColumns selects a product_reviews row aliased r joined to its author
+// aliased u. Scan the result with scanReview.
+const reviewColumns = `r.id, r.product_id, r.user_id, u.full_name, r.order_id, r.rating, COALESCE(r.title, ''), COALESCE(r.body, ''),
+	r.status, COALESCE(r.moderation_note, ''), r.moderated_at, r.helpful_count, r.created_at, r.updated_at`
+
+const reviewFrom = ` FROM product_reviews r JOIN users u ON u.id = r.user_id`
+
+func scanReview(row interface{ Scan(...interface{}) error }) (models.Review, error) {
+	var r models.Review
+	err := row.Scan(&r.ID, &r.ProductID, &r.UserID, &r.AuthorName, &r.OrderID, &r.Rating, &r.Title, &r.Body,
+		&r.Status, &r.ModerationNote, &r.ModeratedAt, &r.HelpfulCount, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+// reviewSorts maps the sort query parameter of a review listing to an ORDER
+// BY clause.
+var reviewSorts = map[string]string{
+	"newest":  "r.created_at DESC, r.id",
+	"helpful": "r.helpful_count DESC, r.created_at DESC, r.id",
+	"highest": "r.rating DESC, r.created_at DESC, r.id",
+	"lowest":  "r.rating ASC, r.created_at DESC, r.id",
+}
+
+// Paging defaults and limits for review listings.
+const (
+	defaultReviewLimit = 10
+	maxReviewLimit     = 50
+)
+
+// authorDisplayName shortens a full name to the first name and last initial
+// for public listings.
+func authorDisplayName(fullName string) string {
+	parts := strings.Fields(fullName)
+	switch len(parts) {
+	case 0:
+		return "Customer"
+	case 1:
+		return parts[0]
+	}
+	last, _ := utf8.DecodeRuneInString(parts[len(parts)-1])
+	return parts[0] + " " + string(last) + "."
+}
+
+// refreshProductRating recomputes a product's rating summary from its
+// approved reviews. The product row is locked first so the summary is
+// computed from a snapshot taken after any concurrent moderation commits;
+// otherwise the later of two updates could write a stale average.
+func refreshProductRating(q querier, productID string) error {
+	if _, err := q.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, productID); err != nil {
+		return err
+	}
+	_, err := q.Exec(
+		`UPDATE products p SET rating_average = COALESCE(s.average, 0), rating_count = s.count
+		FROM (SELECT ROUND(AVG(rating), 2) AS average, COUNT(*) AS count FROM product_reviews WHERE product_id = $1 AND status = 'approved') s
+		WHERE p.id = $1`,
+		productID,
+	)
+	return err
+}
+
+func validateReviewRequest(req *models.ReviewRequest) error {
+	req.Title = strings.TrimSpace(req.Title)
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Rating < 1 || req.Rating > 5 {
+		return fiber.NewError(400, "Rating must be between 1 and 5")
+	}
+	if utf8.RuneCountInString(req.Title) > 200 {
+		return fiber.NewError(400, "Title must be at most 200 characters")
+	}
+	if utf8.RuneCountInString(req.Body) > 5000 {
+		return fiber.NewError(400, "Review must be at most 5000 characters")
+	}
+	return nil
+}
+
+// fetchReview loads a review by ID.
+func (h *ReviewHandler) fetchReview(id string) (models.Review, error) {
+	return scanReview(h.db.QueryRow(`SELECT `+reviewColumns+reviewFrom+` WHERE r.id = $1`, id))
+}
+
+// @Summary List product reviews
+// @Description Lists approved reviews with the product's rating summary.
+// @Tags Reviews
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 10, max 50)"
+// @Param rating query int false "Only reviews with this rating"
+// @Param sort query string false "newest, helpful, highest or lowest"
+// @Success 200 {object} models.ReviewsResponse
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/reviews [get]
+func (h *ReviewHandler) GetProductReviews(c *fiber.Ctx) error {
+	productID := c.Params("id")
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", defaultReviewLimit)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, maxReviewLimit)
+	sortKey := c.Query("sort", "newest")
+	orderBy, ok := reviewSorts[sortKey]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unknown sort " + sortKey})
+	}
+
+	resp := models.ReviewsResponse{
+		Distribution: map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0},
+		Reviews:      []models.Review{},
+		Page:         page,
+		Limit:        limit,
+	}
+	err := h.db.QueryRow(
+		`SELECT rating_average, rating_count FROM products WHERE id = $1 AND COALESCE(is_active, true)`, productID,
+	).Scan(&resp.RatingAverage, &resp.RatingCount)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	rows, err := h.db.Query(
+		`SELECT rating, COUNT(*) FROM product_reviews WHERE product_id = $1 AND status = 'approved' GROUP BY rating`, productID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	for rows.Next() {
+		var rating, count int
+		if err := rows.Scan(&rating, &count); err == nil {
+			resp.Distribution[strconv.Itoa(rating)] = count
+		}
+	}
+	rows.Close()
+
+	where := ` WHERE r.product_id = $1 AND r.status = 'approved'`
+	args := []interface{}{productID}
+	if rating := c.QueryInt("rating"); rating != 0 {
+		args = append(args, rating)
+		where += fmt.Sprintf(` AND r.rating = $%d`, len(args))
+	}
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM product_reviews r`+where, args...).Scan(&resp.Total); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	args = append(args, limit, (page-1)*limit)
+	rows, err = h.db.Query(
+		fmt.Sprintf(`SELECT %s%s%s ORDER BY %s LIMIT $%d OFFSET $%d`, reviewColumns, reviewFrom, where, orderBy, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan review"})
+		}
+		// Moderation details are for admins only
+		r.AuthorName = authorDisplayName(r.AuthorName)
+		r.ModerationNote, r.ModeratedAt = "", nil
+		resp.Reviews = append(resp.Reviews, r)
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary Review a product
+// @Description Only customers with a delivered order containing the product may review it, once. Reviews are published after moderation.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param review body models.ReviewRequest true "Review"
+// @Success 201 {object} models.Review
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/products/{id}/reviews [post]
+func (h *ReviewHandler) CreateReview(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	productID := c.Params("id")
+	var req models.ReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateReviewRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+
+	var orderID string
+	err := h.db.QueryRow(
+		`SELECT o.id FROM orders o JOIN order_items oi ON oi.order_id = o.id
+		WHERE o.user_id = $1 AND oi.product_id = $2 AND o.status = 'delivered'
+		ORDER BY o.created_at DESC LIMIT 1`,
+		userID, productID,
+	).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		return c.Status(403).JSON(fiber.Map{"error": "Only customers who have received this product can review it"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify purchase"})
+	}
+
+	var id string
+	err = h.db.QueryRow(
+		`INSERT INTO product_reviews (product_id, user_id, order_id, rating, title, body)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, '')) RETURNING id`,
+		productID, userID, orderID, req.Rating, req.Title, req.Body,
+	).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return c.Status(409).JSON(fiber.Map{"error": "You have already reviewed this product"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save review"})
+	}
+	r, err := h.fetchReview(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch review"})
+	}
+	return c.Status(201).JSON(r)
+}
+
+// @Summary Edit my review
+// @Description The edited review goes back to moderation.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Review ID"
+// @Param review body models.ReviewRequest true "Review"
+// @Success 200 {object} models.Review
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/reviews/{id} [put]
+func (h *ReviewHandler) UpdateReview(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	id := c.Params("id")
+	var req models.ReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateReviewRequest(&req); err != nil {
+		return errorResponse(c, err)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	var productID string
+	err = tx.QueryRow(
+		`UPDATE product_reviews SET rating = $1, title = NULLIF($2, ''), body = NULLIF($3, ''), status = 'pending',
+			moderation_note = NULL, moderated_at = NULL, updated_at = NOW()
+		WHERE id = $4 AND user_id = $5 RETURNING product_id`,
+		req.Rating, req.Title, req.Body, id, userID,
+	).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update review"})
+	}
+	if err := refreshProductRating(tx, productID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update rating"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update review"})
+	}
+	r, err := h.fetchReview(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch review"})
+	}
+	return c.Status(200).JSON(r)
+}
+
+// @Summary Delete a review
+// @Description Authors may delete their own reviews; admins may delete any.
+// @Tags Reviews
+// @Param id path string true "Review ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/reviews/{id} [delete]
+func (h *ReviewHandler) DeleteReview(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	isAdmin := c.Locals("role") == "admin"
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	var productID string
+	err = tx.QueryRow(
+		`DELETE FROM product_reviews WHERE id = $1 AND (user_id = $2 OR $3) RETURNING product_id`,
+		c.Params("id"), userID, isAdmin,
+	).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete review"})
+	}
+	if err := refreshProductRating(tx, productID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update rating"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete review"})
+	}
+	return c.SendStatus(204)
+}
+
+// setHelpfulVote adds or removes the caller's helpful vote and returns the
+// review's new count.
+func (h *ReviewHandler) setHelpfulVote(c *fiber.Ctx, helpful bool) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	id := c.Params("id")
+	var authorID, status string
+	if err := h.db.QueryRow(`SELECT user_id, status FROM product_reviews WHERE id = $1`, id).Scan(&authorID, &status); err != nil || status != "approved" {
+		return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+	}
+	if authorID == userID {
+		return c.Status(400).JSON(fiber.Map{"error": "You cannot vote on your own review"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	if helpful {
+		_, err = tx.Exec(`INSERT INTO review_votes (review_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, userID)
+	} else {
+		_, err = tx.Exec(`DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2`, id, userID)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save vote"})
+	}
+	var count int
+	err = tx.QueryRow(
+		`UPDATE product_reviews SET helpful_count = (SELECT COUNT(*) FROM review_votes WHERE review_id = $1)
+		WHERE id = $1 RETURNING helpful_count`, id,
+	).Scan(&count)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save vote"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save vote"})
+	}
+	return c.Status(200).JSON(fiber.Map{"helpful_count": count, "voted": helpful})
+}
+
+// @Summary Mark a review as helpful
+// @Tags Reviews
+// @Produce json
+// @Param id path string true "Review ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/reviews/{id}/helpful [post]
+func (h *ReviewHandler) VoteHelpful(c *fiber.Ctx) error {
+	return h.setHelpfulVote(c, true)
+}
+
+// @Summary Remove my helpful vote
+// @Tags Reviews
+// @Produce json
+// @Param id path string true "Review ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/reviews/{id}/helpful [delete]
+func (h *ReviewHandler) RemoveHelpfulVote(c *fiber.Ctx) error {
+	return h.setHelpfulVote(c, false)
+}
+
+// @Summary List reviews for moderation (admin)
+// @Description Lists reviews by status, oldest first. Defaults to pending.
+// @Tags Reviews
+// @Produce json
+// @Param status query string false "pending, approved or rejected"
+// @Success 200 {array} models.Review
+// @Security BearerAuth
+// @Router /api/admin/reviews [get]
+func (h *ReviewHandler) GetReviews(c *fiber.Ctx) error {
+	status := c.Query("status", "pending")
+	if status != "pending" && status != "approved" && status != "rejected" {
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be pending, approved or rejected"})
+	}
+	rows, err := h.db.Query(`SELECT `+reviewColumns+reviewFrom+` WHERE r.status = $1 ORDER BY r.created_at LIMIT 200`, status)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch reviews"})
+	}
+	defer rows.Close()
+	reviews := []models.Review{}
+	for rows.Next() {
+		r, err := scanReview(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to scan review"})
+		}
+		reviews = append(reviews, r)
+	}
+	return c.Status(200).JSON(reviews)
+}
+
+// @Summary Moderate a review (admin)
+// @Description Approves or rejects a review and refreshes the product's rating.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path string true "Review ID"
+// @Param decision body models.ModerateReviewRequest true "Decision"
+// @Success 200 {object} models.Review
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/reviews/{id} [put]
+func (h *ReviewHandler) ModerateReview(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req models.ModerateReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Status != "approved" && req.Status != "rejected" {
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be approved or rejected"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	var productID string
+	err = tx.QueryRow(
+		`UPDATE product_reviews SET status = $1, moderation_note = NULLIF($2, ''), moderated_at = NOW(), updated_at = NOW()
+		WHERE id = $3 RETURNING product_id`,
+		req.Status, strings.TrimSpace(req.Note), id,
+	).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Review not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to moderate review"})
+	}
+	if err := refreshProductRating(tx, productID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update rating"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to moderate review"})
+	}
+	r, err := h.fetchReview(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch review"})
+	}
+	return c.Status(200).JSON(r)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type SearchHandler struct {
+	db *sql.DB
+}
+
+func NewSearchHandler(db *sql.DB) *SearchHandler {
+	return &SearchHandler{db: db}
+}
+
+// Longest search query accepted, in runes.
+const maxSearchQueryLength = 100
+
+// searchTerms splits a free-text query into words, dropping punctuation so
+// nothing the user types can break tsquery syntax.
+func searchTerms(q string) []string {
+	if len([]rune(q)) > maxSearchQueryLength {
+		q = string([]rune(q)[:maxSearchQueryLength])
+	}
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixTSQuery turns words into a tsquery source that matches documents
+// containing every word as a prefix, so partial words like "ultrab" match.
+func prefixTSQuery(words []string) string {
+	parts := make([]string, len(words))
+	for i, w := range words {
+		parts[i] = w + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// @Summary Search products
+// @Description Full-text search over product name, category and description, ranked by relevance. Every word is prefix matched.
+// @Tags Products
+// @Produce json
+// @Param q query string true "Search text"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Success 200 {object} models.ProductSearchResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/products/search [get]
+func (h *SearchHandler) SearchProducts(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	words := searchTerms(q)
+	if len(words) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Search text is required"})
+	}
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", defaultProductLimit)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, maxProductLimit)
+
+	tsq := prefixTSQuery(words)
+	resp := models.ProductSearchResponse{Query: q, Results: []models.ProductSearchResult{}, Page: page, Limit: limit}
+	err := h.db.QueryRow(
+		`SELECT COUNT(*) FROM products p WHERE COALESCE(p.is_active, true) AND p.search_vector @@ to_tsquery('english', $1)`, tsq,
+	).Scan(&resp.Total)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search products"})
+	}
+	if resp.Total == 0 {
+		if suggestion, err := h.didYouMean(words); err == nil && suggestion != strings.Join(words, " ") {
+			resp.DidYouMean = suggestion
+		}
+		return c.Status(200).JSON(resp)
+	}
+
+	rows, err := h.db.Query(
+		`SELECT `+productColumns+`, ts_rank_cd(p.search_vector, query) AS rank,
+			ts_headline('english', p.name, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+			ts_headline('english', COALESCE(NULLIF(p.description, ''), p.name), query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2')
+		FROM products p, to_tsquery('english', $1) query
+		WHERE COALESCE(p.is_active, true) AND p.search_vector @@ query
+		ORDER BY rank DESC, p.created_at DESC, p.id
+		LIMIT $2 OFFSET $3`,
+		tsq, limit, (page-1)*limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search products"})
+	}
+	defer rows.Close()
+	rules, err := loadActivePromotions(h.db)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch promotions"})
+	}
+	for rows.Next() {
+		var r models.ProductSearchResult
+		dest := append(productScanDest(&r.Product), &r.Rank, &r.NameHTML, &r.SnippetHTML)
+		if err := rows.Scan(dest...); err == nil {
+			setEffectivePrice(rules, &r.Product)
+			resp.Results = append(resp.Results, r)
+		}
+	}
+	products := make([]models.Product, len(resp.Results))
+	for i := range resp.Results {
+		products[i] = resp.Results[i].Product
+	}
+	if err := attachProductImages(h.db, products); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product images"})
+	}
+	for i := range products {
+		resp.Results[i].Images = products[i].Images
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// didYouMean replaces each word that does not appear in the catalogue with
+// the most similar word that does. Words with no close match are kept.
+func (h *SearchHandler) didYouMean(words []string) (string, error) {
+	corrected := make([]string, len(words))
+	for i, w := range words {
+		corrected[i] = w
+		var best string
+		err := h.db.QueryRow(
+			`SELECT word FROM search_words WHERE word % $1
+			ORDER BY word = $1 DESC, similarity(word, $1) DESC, product_count DESC LIMIT 1`, w,
+		).Scan(&best)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		corrected[i] = best
+	}
+	return strings.Join(corrected, " "), nil
+}
+
+// Autocomplete limits: queries shorter than this get no suggestions, and at
+// most this many suggestions of each kind are returned.
+const (
+	minSuggestQueryLength = 2
+	maxSuggestions        = 8
+)
+
+// @Summary Suggest products and categories
+// @Description Autocomplete for the search box. Matches prefixes first and tolerates typos using trigram similarity.
+// @Tags Products
+// @Produce json
+// @Param q query string true "Text typed so far"
+// @Success 200 {array} models.SearchSuggestion
+// @Router /api/products/suggest [get]
+func (h *SearchHandler) Suggest(c *fiber.Ctx) error {
+	q := strings.Join(searchTerms(c.Query("q")), " ")
+	suggestions := []models.SearchSuggestion{}
+	if len([]rune(q)) < minSuggestQueryLength {
+		return c.Status(200).JSON(suggestions)
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+
+	// Category matches come first as they narrow the search the most
+	rows, err := h.db.Query(
+		`SELECT category FROM (
+			SELECT DISTINCT ON (LOWER(p.category)) p.category, LOWER(p.category) LIKE $1 || '%' AS prefix, word_similarity($1, LOWER(p.category)) AS score
+			FROM products p
+			WHERE COALESCE(p.is_active, true) AND p.category <> '' AND (LOWER(p.category) LIKE $1 || '%' OR $1 <% LOWER(p.category))
+			ORDER BY LOWER(p.category)
+		) t ORDER BY prefix DESC, score DESC LIMIT $2`,
+		q, maxSuggestions/2,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch suggestions"})
+	}
+	for rows.Next() {
+		var s models.SearchSuggestion
+		if err := rows.Scan(&s.Text); err == nil {
+			s.Type = "category"
+			suggestions = append(suggestions, s)
+		}
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(
+		`SELECT p.id, p.name FROM products p
+		WHERE COALESCE(p.is_active, true) AND (LOWER(p.name) LIKE $1 || '%' OR $1 <% LOWER(p.name))
+		ORDER BY LOWER(p.name) LIKE $1 || '%' DESC, word_similarity($1, LOWER(p.name)) DESC, p.name
+		LIMIT $2`,
+		q, maxSuggestions,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch suggestions"})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.SearchSuggestion
+		var id uuid.UUID
+		if err := rows.Scan(&id, &s.Text); err == nil {
+			s.Type, s.ProductID = "product", &id
+			suggestions = append(suggestions, s)
+		}
+	}
+	return c.Status(200).JSON(suggestions)
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const stockTransferColumns = `t.id, t.from_location_id, f.name, t.to_location_id, d.name, t.status, t.note, t.created_by, t.created_at, t.completed_at`
+
+const stockTransferFrom = ` FROM stock_transfers t JOIN locations f ON f.id = t.from_location_id JOIN locations d ON d.id = t.to_location_id`
+
+func scanStockTransfer(row interface{ Scan(...interface{}) error }) (models.StockTransfer, error) {
+	var t models.StockTransfer
+	err := row.Scan(&t.ID, &t.FromLocationID, &t.FromLocation, &t.ToLocationID, &t.ToLocation, &t.Status, &t.Note, &t.CreatedBy, &t.CreatedAt, &t.CompletedAt)
+	return t, err
+}
+
+// fetchStockTransfer loads a transfer and its items.
+func fetchStockTransfer(q querier, id string) (models.StockTransfer, error) {
+	t, err := scanStockTransfer(q.QueryRow(`SELECT `+stockTransferColumns+stockTransferFrom+` WHERE t.id = $1`, id))
+	if err != nil {
+		return t, err
+	}
+	rows, err := q.Query(
+		`SELECT i.product_id, i.variant_id, p.name, `+variantLabelExpr+`, i.quantity
+		FROM stock_transfer_items i
+		JOIN products p ON p.id = i.product_id
+		LEFT JOIN product_variants v ON v.id = i.variant_id
+		WHERE i.transfer_id = $1 ORDER BY i.product_id, i.variant_id NULLS FIRST`, id)
+	if err != nil {
+		return t, err
+	}
+	defer rows.Close()
+	t.Items = []models.StockTransferItem{}
+	for rows.Next() {
+		var item models.StockTransferItem
+		if err := rows.Scan(&item.ProductID, &item.VariantID, &item.ProductName, &item.VariantLabel, &item.Quantity); err != nil {
+			return t, err
+		}
+		t.Items = append(t.Items, item)
+	}
+	return t, rows.Err()
+}
+
+// transferStock moves a transfer's items in or out of a location. Product
+// rows are locked in ID order first, as placeOrder does.
+func transferStock(tx *sql.Tx, t models.StockTransfer, locationID uuid.UUID, sign int, reason, actorID string) error {
+	for _, item := range t.Items {
+		if _, err := tx.Exec(`SELECT id FROM products WHERE id = $1 FOR UPDATE`, item.ProductID); err != nil {
+			return err
+		}
+		m := stockMovement{
+			productID: item.ProductID.String(), variantID: nullUUID(item.VariantID), locationID: locationID.String(),
+			quantity: sign * item.Quantity, movementType: "transfer", reason: reason, transferID: t.ID.String(), actorID: actorID,
+		}
+		if err := recordStockMovement(tx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// @Summary Transfer stock between locations (admin)
+// @Description Sends stock from one location to another. It leaves the source straight away and is not sellable until the transfer is received. Stock held for unpaid orders cannot be transferred.
+// @Tags Inventory
+// @Accept json
+// @Produce json
+// @Param transfer body models.StockTransferRequest true "Transfer"
+// @Success 201 {object} models.StockTransfer
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/transfers [post]
+func (h *InventoryHandler) CreateTransfer(c *fiber.Ctx) error {
+	actorID, _ := c.Locals("user_id").(string)
+	var req models.StockTransferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.FromLocationID == req.ToLocationID {
+		return c.Status(400).JSON(fiber.Map{"error": "Choose two different locations"})
+	}
+	if len(req.Items) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "A transfer needs at least one item"})
+	}
+	// Merge repeated items and lock products in ID order
+	type itemKey struct{ product, variant uuid.UUID }
+	merged := map[itemKey]*models.StockTransferItem{}
+	var items []*models.StockTransferItem
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Quantities must be positive"})
+		}
+		k := itemKey{product: item.ProductID}
+		if item.VariantID != nil {
+			k.variant = *item.VariantID
+		}
+		if m, ok := merged[k]; ok {
+			m.Quantity += item.Quantity
+			continue
+		}
+		item := item
+		merged[k] = &item
+		items = append(items, &item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ProductID != items[j].ProductID {
+			return items[i].ProductID.String() < items[j].ProductID.String()
+		}
+		return nullUUID(items[i].VariantID).UUID.String() < nullUUID(items[j].VariantID).UUID.String()
+	})
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var fromName, toName string
+	var toActive bool
+	if err := tx.QueryRow(`SELECT name FROM locations WHERE id = $1 AND is_active`, req.FromLocationID).Scan(&fromName); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Source location not found"})
+	}
+	if err := tx.QueryRow(`SELECT name, is_active FROM locations WHERE id = $1`, req.ToLocationID).Scan(&toName, &toActive); err != nil || !toActive {
+		return c.Status(400).JSON(fiber.Map{"error": "Destination location not found"})
+	}
+
+	for _, item := range items {
+		var name string
+		var hasVariants bool
+		var available int
+		err := tx.QueryRow(
+			`SELECT p.name, `+hasVariantsExpr+`, `+availableStockExpr+` FROM products p WHERE p.id = $1 FOR UPDATE`, item.ProductID,
+		).Scan(&name, &hasVariants, &available)
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "Product not found"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to load product"})
+		}
+		if item.VariantID != nil {
+			var label string
+			err := tx.QueryRow(
+				`SELECT `+variantLabelExpr+`, `+variantAvailableStockExpr+` FROM product_variants v WHERE v.id = $1 AND v.product_id = $2 FOR UPDATE`,
+				*item.VariantID, item.ProductID,
+			).Scan(&label, &available)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Variant not found for " + name})
+			}
+			name = variantName(name, label)
+		} else if hasVariants {
+			return c.Status(400).JSON(fiber.Map{"error": "Choose a variant of " + name})
+		}
+		_, held, err := locationQuantity(tx, req.FromLocationID.String(), item.ProductID.String(), nullUUID(item.VariantID))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to load location stock"})
+		}
+		if held < item.Quantity {
+			return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Only %d of %s at %s", held, name, fromName)})
+		}
+		if available < item.Quantity {
+			return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Only %d of %s can be transferred; the rest is held for orders", max(available, 0), name)})
+		}
+	}
+
+	var id string
+	err = tx.QueryRow(
+		`INSERT INTO stock_transfers (from_location_id, to_location_id, note, created_by) VALUES ($1, $2, $3, NULLIF($4, '')::uuid) RETURNING id`,
+		req.FromLocationID, req.ToLocationID, strings.TrimSpace(req.Note), actorID,
+	).Scan(&id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create transfer"})
+	}
+	for _, item := range items {
+		_, err := tx.Exec(
+			`INSERT INTO stock_transfer_items (transfer_id, product_id, variant_id, quantity) VALUES ($1, $2, $3, $4)`,
+			id, item.ProductID, item.VariantID, item.Quantity,
+		)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create transfer"})
+		}
+	}
+	t, err := fetchStockTransfer(tx, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create transfer"})
+	}
+	if err := transferStock(tx, t, t.FromLocationID, -1, "Transfer to "+toName, actorID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to move stock"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create transfer"})
+	}
+	return c.Status(201).JSON(t)
+}
+
+// @Summary List stock transfers (admin)
+// @Tags Inventory
+// @Produce json
+// @Param status query string false "in_transit, received or cancelled"
+// @Param page query int false "Page number (default 1)"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {array} models.StockTransfer
+// @Security BearerAuth
+// @Router /api/admin/inventory/transfers [get]
+func (h *InventoryHandler) GetTransfers(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 || limit < 1 {
+		return c.Status(400).JSON(fiber.Map{"error": "Page and limit must be positive"})
+	}
+	limit = min(limit, 200)
+	rows, err := h.db.Query(
+		`SELECT t.id`+stockTransferFrom+` WHERE ($1 = '' OR t.status = $1) ORDER BY t.created_at DESC, t.id LIMIT $2 OFFSET $3`,
+		c.Query("status"), limit, (page-1)*limit,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transfers"})
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transfers"})
+	}
+	transfers := make([]models.StockTransfer, 0, len(ids))
+	for _, id := range ids {
+		t, err := fetchStockTransfer(h.db, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transfers"})
+		}
+		transfers = append(transfers, t)
+	}
+	return c.Status(200).JSON(transfers)
+}
+
+// scanIDs reads a single column of IDs and closes rows.
+func scanIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// @Summary Get a stock transfer (admin)
+// @Tags Inventory
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.StockTransfer
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/transfers/{id} [get]
+func (h *InventoryHandler) GetTransfer(c *fiber.Ctx) error {
+	t, err := fetchStockTransfer(h.db, c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transfer not found"})
+	}
+	return c.Status(200).JSON(t)
+}
+
+// completeTransfer receives a transfer in transit at its destination, or
+// cancels it and returns the stock to its source.
+func (h *InventoryHandler) completeTransfer(c *fiber.Ctx, status string) error {
+	actorID, _ := c.Locals("user_id").(string)
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRow(`SELECT status FROM stock_transfers WHERE id = $1 FOR UPDATE`, c.Params("id")).Scan(&current)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transfer not found"})
+	}
+	if current != "in_transit" {
+		return c.Status(409).JSON(fiber.Map{"error": "Transfer is already " + current})
+	}
+	t, err := fetchStockTransfer(tx, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load transfer"})
+	}
+	if status == "received" {
+		err = transferStock(tx, t, t.ToLocationID, 1, "Transfer from "+t.FromLocation, actorID)
+	} else {
+		err = transferStock(tx, t, t.FromLocationID, 1, "Transfer to "+t.ToLocation+" cancelled", actorID)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to move stock"})
+	}
+	if _, err := tx.Exec(`UPDATE stock_transfers SET status = $1, completed_at = NOW() WHERE id = $2`, status, t.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update transfer"})
+	}
+	if t, err = fetchStockTransfer(tx, t.ID.String()); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load transfer"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update transfer"})
+	}
+	return c.Status(200).JSON(t)
+}
+
+// @Summary Receive a stock transfer (admin)
+// @Description Adds the transferred stock to the destination.
+// @Tags Inventory
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.StockTransfer
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/transfers/{id}/receive [post]
+func (h *InventoryHandler) ReceiveTransfer(c *fiber.Ctx) error {
+	return h.completeTransfer(c, "received")
+}
+
+// @Summary Cancel a stock transfer (admin)
+// @Description Returns the stock of a transfer still in transit to its source.
+// @Tags Inventory
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.StockTransfer
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/admin/inventory/transfers/{id}/cancel [post]
+func (h *InventoryHandler) CancelTransfer(c *fiber.Ctx) error {
+	return h.completeTransfer(c, "cancelled")
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"ecommerce-backend/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UploadHandler serves /uploads URLs from an object store that the API does
+// not host itself.
+type UploadHandler struct {
+	store storage.Storage
+}
+
+func NewUploadHandler(store storage.Storage) *UploadHandler {
+	return &UploadHandler{store: store}
+}
+
+// @Summary Fetch an uploaded file
+// @Description Redirects to the object in the configured store, signing the link when the bucket is private.
+// @Tags Uploads
+// @Param key path string true "Object key"
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Router /uploads/{key} [get]
+func (h *UploadHandler) Serve(c *fiber.Ctx) error {
+	url, err := h.store.URL(c.Params("*"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "File not found"})
+	}
+	// Signed links expire, so clients should come back for a fresh one
+	c.Set("Cache-Control", "private, max-age=60")
+	return c.Redirect(url, fiber.StatusFound)
+}
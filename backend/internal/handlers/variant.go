@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// variantColumns selects a product_variants row aliased v. Scan the result
+// with scanVariant.
+const variantColumns = `v.id, v.product_id, v.sku, v.price, v.stock, ` + variantAvailableStockExpr + `, v.options, ` + variantLabelExpr + `,
+	COALESCE(v.image_url, ''), v.is_active, v.created_at, v.updated_at`
+
+func scanVariant(row interface{ Scan(...interface{}) error }) (models.ProductVariant, error) {
+	var v models.ProductVariant
+	var price sql.NullFloat64
+	var options []byte
+	err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &price, &v.Stock, &v.AvailableStock, &options, &v.Label,
+		&v.ImageURL, &v.IsActive, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		return v, err
+	}
+	if price.Valid {
+		v.Price = &price.Float64
+	}
+	v.Options = map[string]string{}
+	err = json.Unmarshal(options, &v.Options)
+	return v, err
+}
+
+// loadVariants returns the option types and variants of a product. Inactive
+// variants are only included when includeInactive is set; option values are
+// taken from the active variants.
+func loadVariants(q querier, productID uuid.UUID, includeInactive bool) ([]models.ProductOptionType, []models.ProductVariant, error) {
+	options := []models.ProductOptionType{}
+	rows, err := q.Query(`SELECT id, name, position FROM product_option_types WHERE product_id = $1 ORDER BY position, name`, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		ot := models.ProductOptionType{Values: []string{}}
+		if err := rows.Scan(&ot.ID, &ot.Name, &ot.Position); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		options = append(options, ot)
+	}
+	rows.Close()
+
+	variants := []models.ProductVariant{}
+	rows, err = q.Query(
+		`SELECT `+variantColumns+` FROM product_variants v WHERE v.product_id = $1 AND (v.is_active OR $2) ORDER BY v.created_at, v.sku`,
+		productID, includeInactive,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		variants = append(variants, v)
+		if !v.IsActive {
+			continue
+		}
+		for i := range options {
+			if val, ok := v.Options[options[i].Name]; ok && !containsFold(options[i].Values, val) {
+				options[i].Values = append(options[i].Values, val)
+			}
+		}
+	}
+	return options, variants, rows.Err()
+}
+
+// validateVariantRequest checks a variant against the product's option
+// types: every option must be given a value and no others are allowed.
+func validateVariantRequest(q querier, productID string, req *models.ProductVariantRequest) error {
+	req.SKU = strings.ToUpper(strings.TrimSpace(req.SKU))
+	if req.SKU == "" {
+		return fiber.NewError(400, "SKU is required")
+	}
+	if req.Price != nil && *req.Price <= 0 {
+		return fiber.NewError(400, "Price must be positive")
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		return fiber.NewError(400, "Stock cannot be negative")
+	}
+
+	rows, err := q.Query(`SELECT name FROM product_option_types WHERE product_id = $1`, productID)
+	if err != nil {
+		return fiber.NewError(500, "Failed to load product options")
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fiber.NewError(500, "Failed to load product options")
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return fiber.NewError(400, "Set the product's options before adding variants")
+	}
+	for _, name := range names {
+		if strings.TrimSpace(req.Options[name]) == "" {
+			return fiber.NewError(400, "A value for "+name+" is required")
+		}
+		req.Options[name] = strings.TrimSpace(req.Options[name])
+	}
+	if len(req.Options) != len(names) {
+		return fiber.NewError(400, "Options must match the product's options: "+strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// fetchVariant loads a single variant of a product.
+func (h *ProductHandler) fetchVariant(productID, variantID string) (models.ProductVariant, error) {
+	return scanVariant(h.db.QueryRow(`SELECT `+variantColumns+` FROM product_variants v WHERE v.id = $1 AND v.product_id = $2`, variantID, productID))
+}
+
+// saveVariantError maps a failed variant insert or update to a response.
+func saveVariantError(c *fiber.Ctx, err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		if strings.Contains(pqErr.Constraint, "options") {
+			return c.Status(409).JSON(fiber.Map{"error": "A variant with these options already exists"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "SKU already exists"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": "Failed to save variant"})
+}
+
+// @Summary List product variants
+// @Description Admins may pass include_inactive=true to see deactivated variants.
+// @Tags Products
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param include_inactive query bool false "Include inactive variants (admin)"
+// @Success 200 {array} models.ProductVariant
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/variants [get]
+func (h *ProductHandler) GetVariants(c *fiber.Ctx) error {
+	p, err := h.fetchProduct(c.Params("id"))
+	if err != nil || (!p.IsActive && c.Locals("role") != "admin") {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	if c.QueryBool("include_inactive") && c.Locals("role") == "admin" {
+		_, variants, err := loadVariants(h.db, p.ID, true)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch variants"})
+		}
+		if rules, err := loadActivePromotions(h.db); err == nil {
+			for i := range variants {
+				setVariantEffectivePrice(rules, &p, &variants[i])
+			}
+		}
+		return c.Status(200).JSON(variants)
+	}
+	if p.Variants == nil {
+		p.Variants = []models.ProductVariant{}
+	}
+	return c.Status(200).JSON(p.Variants)
+}
+
+// @Summary Set product options (admin)
+// @Description Replaces the option types (e.g. Size, Colour) a product varies by, in display order.
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param options body models.ProductOptionsRequest true "Option names"
+// @Success 200 {object} models.Product
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/products/{id}/options [put]
+func (h *ProductHandler) SetOptions(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req models.ProductOptionsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	var names []string
+	for _, name := range req.Options {
+		name = strings.TrimSpace(name)
+		if name == "" || containsFold(names, name) {
+			return c.Status(400).JSON(fiber.Map{"error": "Option names must be unique and not empty"})
+		}
+		names = append(names, name)
+	}
+	if _, err := h.fetchProduct(id); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	// Active variants must still describe themselves with the new options
+	var mismatched bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1 AND is_active
+			AND NOT (options ?& $2::text[] AND (SELECT COUNT(*) FROM jsonb_object_keys(options)) = cardinality($2::text[])))`,
+		id, pq.Array(names),
+	).Scan(&mismatched)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check variants"})
+	}
+	if mismatched {
+		return c.Status(409).JSON(fiber.Map{"error": "Active variants use different options; update or deactivate them first"})
+	}
+
+	if _, err := tx.Exec(`DELETE FROM product_option_types WHERE product_id = $1`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save options"})
+	}
+	for i, name := range names {
+		if _, err := tx.Exec(`INSERT INTO product_option_types (product_id, name, position) VALUES ($1, $2, $3)`, id, name, i); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save options"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save options"})
+	}
+
+	p, err := h.fetchProduct(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch product"})
+	}
+	return c.Status(200).JSON(p)
+}
+
+// @Summary Create a product variant (admin)
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variant body models.ProductVariantRequest true "Variant"
+// @Success 201 {object} models.ProductVariant
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/products/{id}/variants [post]
+func (h *ProductHandler) CreateVariant(c *fiber.Ctx) error {
+	productID := c.Params("id")
+	if _, err := h.fetchProduct(productID); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+	}
+	var req models.ProductVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateVariantRequest(h.db, productID, &req); err != nil {
+		return errorResponse(c, err)
+	}
+	options, _ := json.Marshal(req.Options)
+	isActive := req.IsActive == nil || *req.IsActive
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var id uuid.UUID
+	err = tx.QueryRow(
+		`INSERT INTO product_variants (product_id, sku, price, stock, options, image_url, is_active)
+		VALUES ($1, $2, $3, 0, $4, NULLIF($5, ''), $6) RETURNING id`,
+		productID, req.SKU, req.Price, options, req.ImageURL, isActive,
+	).Scan(&id)
+	if err != nil {
+		return saveVariantError(c, err)
+	}
+	if req.Stock != nil {
+		actorID, _ := c.Locals("user_id").(string)
+		opening := stockMovement{
+			productID: productID, variantID: uuid.NullUUID{UUID: id, Valid: true},
+			quantity: *req.Stock, movementType: "restock", reason: "Opening stock", actorID: actorID,
+		}
+		if err := recordStockMovement(tx, opening); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create variant"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create variant"})
+	}
+	v, err := h.fetchVariant(productID, id.String())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch variant"})
+	}
+	return c.Status(201).JSON(v)
+}
+
+// @Summary Update a product variant (admin)
+// @Description SKU and options are required. Price, stock, image and active flag keep their current values when left out.
+// @Tags Products
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variantId path string true "Variant ID"
+// @Param variant body models.ProductVariantRequest true "Variant"
+// @Success 200 {object} models.ProductVariant
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/products/{id}/variants/{variantId} [put]
+func (h *ProductHandler) UpdateVariant(c *fiber.Ctx) error {
+	productID, variantID := c.Params("id"), c.Params("variantId")
+	current, err := h.fetchVariant(productID, variantID)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+	}
+	var req models.ProductVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := validateVariantRequest(h.db, productID, &req); err != nil {
+		return errorResponse(c, err)
+	}
+	if req.ImageURL == "" {
+		req.ImageURL = current.ImageURL
+	}
+	if req.Price == nil {
+		req.Price = current.Price
+	}
+	options, _ := json.Marshal(req.Options)
+	isActive := current.IsActive
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`UPDATE product_variants SET sku = $1, price = $2, options = $3, image_url = NULLIF($4, ''), is_active = $5, updated_at = NOW()
+		WHERE id = $6`,
+		req.SKU, req.Price, options, req.ImageURL, isActive, variantID,
+	)
+	if err != nil {
+		return saveVariantError(c, err)
+	}
+	if req.Stock != nil {
+		actorID, _ := c.Locals("user_id").(string)
+		if err := setStockLevel(tx, productID, uuid.NullUUID{UUID: current.ID, Valid: true}, *req.Stock, actorID); err != nil {
+			if _, ok := err.(*fiber.Error); ok {
+				return errorResponse(c, err)
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update stock"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update variant"})
+	}
+	v, err := h.fetchVariant(productID, variantID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch variant"})
+	}
+	return c.Status(200).JSON(v)
+}
+
+// @Summary Deactivate a product variant (admin)
+// @Description Variants are kept for order history and only deactivated.
+// @Tags Products
+// @Param id path string true "Product ID"
+// @Param variantId path string true "Variant ID"
+// @Success 204 {object} nil
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/variants/{variantId} [delete]
+func (h *ProductHandler) DeleteVariant(c *fiber.Ctx) error {
+	res, err := h.db.Exec(
+		`UPDATE product_variants SET is_active = false, updated_at = NOW() WHERE id = $1 AND product_id = $2`,
+		c.Params("variantId"), c.Params("id"),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate variant"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// @Summary Upload a variant image (admin)
+// @Tags Products
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param variantId path string true "Variant ID"
+// @Param image formData file true "Variant image"
+// @Success 200 {object} models.ProductVariant
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/products/{id}/variants/{variantId}/image [post]
+func (h *ProductHandler) UploadVariantImage(c *fiber.Ctx) error {
+	productID, variantID := c.Params("id"), c.Params("variantId")
+	if _, err := h.fetchVariant(productID, variantID); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Variant not found"})
+	}
+	file, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Image file is required"})
+	}
+	image, err := h.saveImage(c.UserContext(), file)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	if _, err := h.db.Exec(`UPDATE product_variants SET image_url = $1, updated_at = NOW() WHERE id = $2`, image.URL, variantID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update variant image"})
+	}
+	v, err := h.fetchVariant(productID, variantID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch variant"})
+	}
+	return c.Status(200).JSON(v)
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type WalletHandler struct {
+	db *sql.DB
+}
+
+func NewWalletHandler(db *sql.DB) *WalletHandler {
+	return &WalletHandler{db: db}
+}
+
+// Limits on the value of a single gift card.
+const (
+	minGiftCardAmount = 100
+	maxGiftCardAmount = 100000
+)
+
+// newGiftCardCode returns a hard to guess gift card code.
+func newGiftCardCode() string {
+	return "GC-" + randomCode(4) + "-" + randomCode(4) + "-" + randomCode(4)
+}
+
+// normalizeGiftCardCode makes codes case-insensitive.
+func normalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+const giftCardColumns = `id, code, initial_balance, balance, COALESCE(recipient_email, ''), COALESCE(message, ''), expires_at, is_active, created_at`
+
+func scanGiftCard(row interface{ Scan(...interface{}) error }) (models.GiftCard, error) {
+	var gc models.GiftCard
+	err := row.Scan(&gc.ID, &gc.Code, &gc.InitialBalance, &gc.Balance, &gc.RecipientEmail, &gc.Message, &gc.ExpiresAt, &gc.IsActive, &gc.CreatedAt)
+	return gc, err
+}
+
+// createGiftCard inserts a gift card and its opening ledger entry. Cards
+// bought by a customer stay inactive until purchaseOrderID is paid.
+func createGiftCard(tx *sql.Tx, amount float64, recipientEmail, message string, expiresAt *time.Time, issuedBy, purchasedBy, purchaseOrderID interface{}) (models.GiftCard, error) {
+	var gc models.GiftCard
+	var err error
+	// Retry on the rare code collision
+	for attempt := 0; attempt < 3; attempt++ {
+		gc, err = scanGiftCard(tx.QueryRow(
+			`INSERT INTO gift_cards (code, initial_balance, balance, recipient_email, message, expires_at, issued_by, purchased_by, purchase_order_id, is_active)
+			VALUES ($1, $2, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, $9)
+			RETURNING `+giftCardColumns,
+			newGiftCardCode(), amount, recipientEmail, message, expiresAt, issuedBy, purchasedBy, purchaseOrderID, purchaseOrderID == nil,
+		))
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return gc, err
+	}
+	_, err = tx.Exec(`INSERT INTO gift_card_transactions (gift_card_id, amount, entry_type) VALUES ($1, $2, 'issue')`, gc.ID, amount)
+	return gc, err
+}
+
+// activatePurchasedGiftCards enables the gift cards bought with an order once
+// it has been paid.
+func activatePurchasedGiftCards(tx *sql.Tx, orderID string) error {
+	_, err := tx.Exec(`UPDATE gift_cards SET is_active = true, updated_at = NOW() WHERE purchase_order_id = $1 AND is_active = false`, orderID)
+	return err
+}
+
+// voidPurchasedGiftCards disables the gift cards bought with an order that
+// is being cancelled or refunded so their balance can no longer be spent.
+func voidPurchasedGiftCards(tx *sql.Tx, orderID string) error {
+	_, err := tx.Exec(`UPDATE gift_cards SET is_active = false, updated_at = NOW() WHERE purchase_order_id = $1 AND is_active = true`, orderID)
+	return err
+}
+
+// redeemGiftCard spends up to amount from the gift card with code towards an
+// order and returns how much was taken. The card row is locked so concurrent
+// checkouts cannot overspend it.
+func redeemGiftCard(tx *sql.Tx, code, orderID string, amount float64) (float64, error) {
+	var cardID string
+	var balance float64
+	var expiresAt sql.NullTime
+	err := tx.QueryRow(`SELECT id, balance, expires_at FROM gift_cards WHERE code = $1 AND is_active = true FOR UPDATE`, normalizeGiftCardCode(code)).
+		Scan(&cardID, &balance, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, fiber.NewError(404, "Gift card not found")
+	}
+	if err != nil {
+		return 0, fiber.NewError(500, "Failed to load gift card")
+	}
+	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
+		return 0, fiber.NewError(400, "Gift card has expired")
+	}
+	if balance <= 0 {
+		return 0, fiber.NewError(400, "Gift card has no remaining balance")
+	}
+
+	used := roundMoney(min(balance, amount))
+	if used <= 0 {
+		return 0, nil
+	}
+	if _, err := tx.Exec(`UPDATE gift_cards SET balance = balance - $1, updated_at = NOW() WHERE id = $2`, used, cardID); err != nil {
+		return 0, fiber.NewError(500, "Failed to redeem gift card")
+	}
+	_, err = tx.Exec(`INSERT INTO gift_card_transactions (gift_card_id, order_id, amount, entry_type) VALUES ($1, $2, $3, 'redeem')`, cardID, orderID, -used)
+	if err != nil {
+		return 0, fiber.NewError(500, "Failed to redeem gift card")
+	}
+	return used, nil
+}
+
+// lockStoreCredit locks a user's store credit and returns the current
+// balance.
+func lockStoreCredit(tx *sql.Tx, userID string) (float64, error) {
+	if err := lockUser(tx, userID); err != nil {
+		return 0, err
+	}
+	return storeCreditBalance(tx, userID)
+}
+
+// storeCreditBalance sums a user's store credit ledger.
+func storeCreditBalance(q querier, userID string) (float64, error) {
+	var balance float64
+	err := q.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM store_credit_ledger WHERE user_id = $1`, userID).Scan(&balance)
+	return balance, err
+}
+
+// spendStoreCredit takes up to amount of a user's store credit towards an
+// order and returns how much was taken.
+func spendStoreCredit(tx *sql.Tx, userID, orderID string, amount float64) (float64, error) {
+	balance, err := lockStoreCredit(tx, userID)
+	if err != nil {
+		return 0, fiber.NewError(500, "Failed to load store credit")
+	}
+	used := roundMoney(min(balance, amount))
+	if used <= 0 {
+		return 0, nil
+	}
+	_, err = tx.Exec(`INSERT INTO store_credit_ledger (user_id, amount, entry_type, order_id) VALUES ($1, $2, 'redeem', $3)`, userID, -used, orderID)
+	if err != nil {
+		return 0, fiber.NewError(500, "Failed to apply store credit")
+	}
+	return used, nil
+}
+
+// refundOrderTenders gives back any gift card balance and store credit spent
+// on an order. It only returns what has not already been returned, so it is
+// safe to call more than once.
+func refundOrderTenders(tx *sql.Tx, orderID string) error {
+	rows, err := tx.Query(
+		`SELECT gift_card_id, -SUM(amount) FROM gift_card_transactions
+		WHERE order_id = $1 AND entry_type IN ('redeem', 'refund')
+		GROUP BY gift_card_id HAVING SUM(amount) < 0 ORDER BY gift_card_id`, orderID)
+	if err != nil {
+		return err
+	}
+	type refund struct {
+		id     string
+		amount float64
+	}
+	var refunds []refund
+	for rows.Next() {
+		var r refund
+		if err := rows.Scan(&r.id, &r.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		refunds = append(refunds, r)
+	}
+	rows.Close()
+	for _, r := range refunds {
+		if _, err := tx.Exec(`UPDATE gift_cards SET balance = balance + $1, updated_at = NOW() WHERE id = $2`, r.amount, r.id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO gift_card_transactions (gift_card_id, order_id, amount, entry_type) VALUES ($1, $2, $3, 'refund')`, r.id, orderID, r.amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	var userID sql.NullString
+	var spent float64
+	err = tx.QueryRow(
+		`SELECT user_id, -SUM(amount) FROM store_credit_ledger
+		WHERE order_id = $1 AND entry_type IN ('redeem', 'reversal')
+		GROUP BY user_id HAVING SUM(amount) < 0`, orderID,
+	).Scan(&userID, &spent)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := lockStoreCredit(tx, userID.String); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO store_credit_ledger (user_id, amount, entry_type, order_id) VALUES ($1, $2, 'reversal', $3)`, userID.String, spent, orderID)
+	return err
+}
+
+// storeCreditStatement returns a user's balance and ledger, newest first.
+func storeCreditStatement(db *sql.DB, userID string) (models.StoreCreditResponse, error) {
+	resp := models.StoreCreditResponse{Entries: []models.StoreCreditEntry{}}
+	balance, err := storeCreditBalance(db, userID)
+	if err != nil {
+		return resp, err
+	}
+	resp.Balance = balance
+	rows, err := db.Query(
+		`SELECT id, amount, entry_type, order_id, COALESCE(note, ''), created_at FROM store_credit_ledger
+		WHERE user_id = $1 ORDER BY created_at DESC LIMIT 100`, userID)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.StoreCreditEntry
+		if err := rows.Scan(&e.ID, &e.Amount, &e.EntryType, &e.OrderID, &e.Note, &e.CreatedAt); err != nil {
+			return resp, err
+		}
+		resp.Entries = append(resp.Entries, e)
+	}
+	return resp, rows.Err()
+}
+
+// @Summary Check a gift card balance
+// @Tags Gift Cards
+// @Produce json
+// @Param code path string true "Gift card code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /api/gift-cards/{code} [get]
+func (h *WalletHandler) CheckGiftCard(c *fiber.Ctx) error {
+	gc, err := scanGiftCard(h.db.QueryRow(`SELECT `+giftCardColumns+` FROM gift_cards WHERE code = $1 AND is_active = true`, normalizeGiftCardCode(c.Params("code"))))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Gift card not found"})
+	}
+	return c.Status(200).JSON(fiber.Map{
+		"code":       gc.Code,
+		"balance":    gc.Balance,
+		"expires_at": gc.ExpiresAt,
+		"expired":    gc.ExpiresAt != nil && gc.ExpiresAt.Before(time.Now()),
+	})
+}
+
+// @Summary Buy a gift card
+// @Description Creates a pending order for the gift card value. The card becomes usable once the order is paid.
+// @Tags Gift Cards
+// @Accept json
+// @Produce json
+// @Param gift_card body models.PurchaseGiftCardRequest true "Gift card"
+// @Success 201 {object} models.GiftCardPurchaseResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/gift-cards [post]
+func (h *WalletHandler) PurchaseGiftCard(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	var req models.PurchaseGiftCardRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Amount < minGiftCardAmount || req.Amount > maxGiftCardAmount {
+		return c.Status(400).JSON(fiber.Map{"error": "Gift card amount must be between 100 and 100000"})
+	}
+	req.RecipientEmail = strings.TrimSpace(req.RecipientEmail)
+	if req.RecipientEmail == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Recipient email is required"})
+	}
+	amount := roundMoney(req.Amount)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	var orderID string
+	err = tx.QueryRow(
+		`INSERT INTO orders (user_id, order_number, status, subtotal_amount, total_amount, amount_due, shipping_address)
+		VALUES ($1, $2, 'pending', $3, $3, $3, $4) RETURNING id`,
+		userID, newOrderNumber(), amount, "Gift card delivered to "+req.RecipientEmail,
+	).Scan(&orderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create order"})
+	}
+	gc, err := createGiftCard(tx, amount, req.RecipientEmail, req.Message, nil, nil, userID, orderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create gift card"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create gift card"})
+	}
+
+	order, err := fetchOrder(h.db, orderID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch order"})
+	}
+	return c.Status(201).JSON(models.GiftCardPurchaseResponse{GiftCard: gc, Order: order})
+}
+
+// @Summary List gift cards (admin)
+// @Tags Gift Cards
+// @Produce json
+// @Success 200 {array} models.GiftCard
+// @Router /api/admin/gift-cards [get]
+func (h *WalletHandler) GetGiftCards(c *fiber.Ctx) error {
+	rows, err := h.db.Query(`SELECT ` + giftCardColumns + ` FROM gift_cards ORDER BY created_at DESC`)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch gift cards"})
+	}
+	defer rows.Close()
+	cards := []models.GiftCard{}
+	for rows.Next() {
+		if gc, err := scanGiftCard(rows); err == nil {
+			cards = append(cards, gc)
+		}
+	}
+	return c.Status(200).JSON(cards)
+}
+
+// @Summary Issue a gift card (admin)
+// @Tags Gift Cards
+// @Accept json
+// @Produce json
+// @Param gift_card body models.IssueGiftCardRequest true "Gift card"
+// @Success 201 {object} models.GiftCard
+// @Failure 400 {object} map[string]string
+// @Router /api/admin/gift-cards [post]
+func (h *WalletHandler) IssueGiftCard(c *fiber.Ctx) error {
+	var req models.IssueGiftCardRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Amount <= 0 || req.Amount > maxGiftCardAmount {
+		return c.Status(400).JSON(fiber.Map{"error": "Gift card amount must be between 0 and 100000"})
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "Expiry must be in the future"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+	gc, err := createGiftCard(tx, roundMoney(req.Amount), strings.TrimSpace(req.RecipientEmail), req.Message, req.ExpiresAt, c.Locals("user_id"), nil, nil)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue gift card"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue gift card"})
+	}
+	return c.Status(201).JSON(gc)
+}
+
+// @Summary Deactivate a gift card (admin)
+// @Tags Gift Cards
+// @Param id path string true "Gift card ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Router /api/admin/gift-cards/{id} [delete]
+func (h *WalletHandler) DeactivateGiftCard(c *fiber.Ctx) error {
+	res, err := h.db.Exec(`UPDATE gift_cards SET is_active = false, updated_at = NOW() WHERE id = $1`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to deactivate gift card"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Gift card not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// @Summary Get my store credit
+// @Tags Store Credit
+// @Produce json
+// @Success 200 {object} models.StoreCreditResponse
+// @Router /api/store-credit [get]
+func (h *WalletHandler) GetMyStoreCredit(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	resp, err := storeCreditStatement(h.db, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch store credit"})
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary Get a user's store credit (admin)
+// @Tags Store Credit
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.StoreCreditResponse
+// @Router /api/admin/users/{id}/store-credit [get]
+func (h *WalletHandler) GetUserStoreCredit(c *fiber.Ctx) error {
+	if _, err := uuid.Parse(c.Params("id")); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+	resp, err := storeCreditStatement(h.db, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch store credit"})
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary Adjust a user's store credit (admin)
+// @Description Credits (positive amount) or debits (negative amount) a user's store credit. Balances cannot go below zero.
+// @Tags Store Credit
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param adjustment body models.StoreCreditAdjustmentRequest true "Adjustment"
+// @Success 201 {object} models.StoreCreditResponse
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/admin/users/{id}/store-credit [post]
+func (h *WalletHandler) AdjustStoreCredit(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+	var req models.StoreCreditAdjustmentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	amount := roundMoney(req.Amount)
+	if amount == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Amount must not be zero"})
+	}
+	if req.EntryType == "" {
+		req.EntryType = "goodwill"
+	}
+	if req.EntryType != "refund" && req.EntryType != "goodwill" && req.EntryType != "adjustment" {
+		return c.Status(400).JSON(fiber.Map{"error": "Entry type must be refund, goodwill or adjustment"})
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start transaction"})
+	}
+	defer tx.Rollback()
+
+	balance, err := lockStoreCredit(tx, userID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to load store credit"})
+	}
+	if balance+amount < 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "Insufficient store credit"})
+	}
+	_, err = tx.Exec(
+		`INSERT INTO store_credit_ledger (user_id, amount, entry_type, order_id, note, created_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`,
+		userID, amount, req.EntryType, req.OrderID, req.Note, c.Locals("user_id"),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust store credit"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust store credit"})
+	}
+
+	resp, err := storeCreditStatement(h.db, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch store credit"})
+	}
+	return c.Status(201).JSON(resp)
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"database/sql"
+	"ecommerce-backend/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type WishlistHandler struct {
+	db          *sql.DB
+	cart        *CartHandler
+	frontendURL string
+}
+
+func NewWishlistHandler(db *sql.DB, cart *CartHandler, frontendURL string) *WishlistHandler {
+	return &WishlistHandler{db: db, cart: cart, frontendURL: frontendURL}
+}
+
+// loadWishlist reads a user's wishlist with current prices, stock and
+// images. Shared views leave out archived products.
+func (h *WishlistHandler) loadWishlist(userID string, shared bool) (models.Wishlist, error) {
+	wl := models.Wishlist{Items: []models.WishlistItem{}}
+	var token sql.NullString
+	err := h.db.QueryRow(`SELECT token FROM wishlist_shares WHERE user_id = $1`, userID).Scan(&token)
+	if err != nil && err != sql.ErrNoRows {
+		return wl, err
+	}
+	if token.Valid && !shared {
+		wl.ShareToken = token.String
+		wl.ShareURL = h.frontendURL + "/wishlists/" + token.String
+	}
+
+	query := `SELECT ` + productColumns + `, w.created_at FROM wishlist_items w JOIN products p ON p.id = w.product_id WHERE w.user_id = $1`
+	if shared {
+		query += ` AND COALESCE(p.is_active, true)`
+	}
+	rows, err := h.db.Query(query+` ORDER BY w.created_at DESC`, userID)
+	if err != nil {
+		return wl, err
+	}
+	defer rows.Close()
+	var products []models.Product
+	var added []models.WishlistItem
+	for rows.Next() {
+		var item models.WishlistItem
+		if err := rows.Scan(append(productScanDest(&item.Product), &item.AddedAt)...); err != nil {
+			return wl, err
+		}
+		products = append(products, item.Product)
+		added = append(added, item)
+	}
+	if err := rows.Err(); err != nil {
+		return wl, err
+	}
+	if err := attachProductImages(h.db, products); err != nil {
+		return wl, err
+	}
+	rules, err := loadActivePromotions(h.db)
+	if err != nil {
+		return wl, err
+	}
+	for i := range added {
+		added[i].Product = products[i]
+		setEffectivePrice(rules, &added[i].Product)
+		added[i].InStock = added[i].Product.IsActive && added[i].Product.AvailableStock > 0
+		wl.Items = append(wl.Items, added[i])
+	}
+	return wl, nil
+}
+
+// wishlistResponse writes the user's wishlist with the given status.
+func (h *WishlistHandler) wishlistResponse(c *fiber.Ctx, status int, userID string) error {
+	wl, err := h.loadWishlist(userID, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist"})
+	}
+	return c.Status(status).JSON(wl)
+}
+
+// @Summary Get my wishlist
+// @Tags Wishlist
+// @Produce json
+// @Success 200 {object} models.Wishlist
+// @Security BearerAuth
+// @Router /api/wishlist [get]
+func (h *WishlistHandler) GetWishlist(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	return h.wishlistResponse(c, 200, userID)
+}
+
+// @Summary Add a product to my wishlist
+// @Tags Wishlist
+// @Accept json
+// @Produce json
+// @Param item body models.AddWishlistItemRequest true "Product"
+// @Success 201 {object} models.Wishlist
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/wishlist [post]
+func (h *WishlistHandler) AddItem(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	var req models.AddWishlistItemRequest
+	if err := c.BodyParser(&req); err != nil || req.ProductID == uuid.Nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Product ID is required"})
+	}
+	res, err := h.db.Exec(
+		`INSERT INTO wishlist_items (user_id, product_id)
+		SELECT $1, id FROM products WHERE id = $2 AND COALESCE(is_active, true)
+		ON CONFLICT DO NOTHING`,
+		userID, req.ProductID,
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save wishlist item"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Either already saved or not a product that can be saved
+		var exists bool
+		if err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM wishlist_items WHERE user_id = $1 AND product_id = $2)`, userID, req.ProductID).Scan(&exists); err != nil || !exists {
+			return c.Status(404).JSON(fiber.Map{"error": "Product not found"})
+		}
+	}
+	return h.wishlistResponse(c, 201, userID)
+}
+
+// @Summary Remove a product from my wishlist
+// @Tags Wishlist
+// @Produce json
+// @Param productId path string true "Product ID"
+// @Success 200 {object} models.Wishlist
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/wishlist/{productId} [delete]
+func (h *WishlistHandler) RemoveItem(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	res, err := h.db.Exec(`DELETE FROM wishlist_items WHERE user_id = $1 AND product_id = $2`, userID, c.Params("productId"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove wishlist item"})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Product is not in your wishlist"})
+	}
+	return h.wishlistResponse(c, 200, userID)
+}
+
+// @Summary Move a wishlist item to the cart
+// @Description Adds the product to the cart (quantity defaults to 1) and removes it from the wishlist. Products with options need a variant_id.
+// @Tags Wishlist
+// @Accept json
+// @Produce json
+// @Param productId path string true "Product ID"
+// @Param item body models.MoveToCartRequest false "Quantity and variant"
+// @Success 200 {object} models.Cart
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/wishlist/{productId}/move-to-cart [post]
+func (h *WishlistHandler) MoveToCart(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	productID, err := uuid.Parse(c.Params("productId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid product ID"})
+	}
+	var req models.MoveToCartRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	var exists bool
+	if err := h.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM wishlist_items WHERE user_id = $1 AND product_id = $2)`, userID, productID).Scan(&exists); err != nil || !exists {
+		return c.Status(404).JSON(fiber.Map{"error": "Product is not in your wishlist"})
+	}
+	cartID, err := h.cart.resolveCart(c, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cart"})
+	}
+	var variantID uuid.NullUUID
+	if req.VariantID != nil {
+		variantID = uuid.NullUUID{UUID: *req.VariantID, Valid: true}
+	}
+	if err := h.cart.setItemQuantity(cartID, productID, variantID, req.Quantity, true); err != nil {
+		return errorResponse(c, err)
+	}
+	if _, err := h.db.Exec(`DELETE FROM wishlist_items WHERE user_id = $1 AND product_id = $2`, userID, productID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to remove wishlist item"})
+	}
+	return h.cart.cartResponse(c, 200, cartID)
+}
+
+// @Summary Share my wishlist
+// @Description Creates a public link to the wishlist, or returns the existing one.
+// @Tags Wishlist
+// @Produce json
+// @Success 200 {object} models.Wishlist
+// @Security BearerAuth
+// @Router /api/wishlist/share [post]
+func (h *WishlistHandler) Share(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	_, err := h.db.Exec(`INSERT INTO wishlist_shares (user_id, token) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`, userID, randomCode(20))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to share wishlist"})
+	}
+	return h.wishlistResponse(c, 200, userID)
+}
+
+// @Summary Stop sharing my wishlist
+// @Description Revokes the public link; sharing again creates a new one.
+// @Tags Wishlist
+// @Produce json
+// @Success 200 {object} models.Wishlist
+// @Security BearerAuth
+// @Router /api/wishlist/share [delete]
+func (h *WishlistHandler) Unshare(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if _, err := h.db.Exec(`DELETE FROM wishlist_shares WHERE user_id = $1`, userID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to stop sharing wishlist"})
+	}
+	return h.wishlistResponse(c, 200, userID)
+}
+
+// @Summary View a shared wishlist
+// @Tags Wishlist
+// @Produce json
+// @Param token path string true "Share token"
+// @Success 200 {object} models.Wishlist
+// @Failure 404 {object} map[string]string
+// @Router /api/wishlists/shared/{token} [get]
+func (h *WishlistHandler) GetSharedWishlist(c *fiber.Ctx) error {
+	var userID, fullName string
+	err := h.db.QueryRow(
+		`SELECT s.user_id, u.full_name FROM wishlist_shares s JOIN users u ON u.id = s.user_id WHERE s.token = $1`,
+		c.Params("token"),
+	).Scan(&userID, &fullName)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wishlist not found"})
+	}
+	wl, err := h.loadWishlist(userID, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch wishlist"})
+	}
+	wl.OwnerName = authorDisplayName(fullName)
+	return c.Status(200).JSON(wl)
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"ecommerce-backend/internal/notifications"
+	"ecommerce-backend/internal/services"
+)
+
+// AbandonedCartJob reminds customers about carts left idle with items in them.
+// A cart gets at most one reminder per idle period: touching the cart again
+// makes it eligible for another one.
+type AbandonedCartJob struct {
+	DB          *sql.DB
+	Notifier    notifications.Notifier
+	IdleAfter   time.Duration
+	FrontendURL string
+	JWTSecret   string
+}
+
+// Carts idle for longer than this are considered dead and never reminded.
+const abandonedCartMaxAge = 7 * 24 * time.Hour
+
+type abandonedCart struct {
+	ID        string
+	IsGuest   bool
+	Email     string
+	Phone     string
+	Name      string
+	ItemCount int
+	Value     float64
+}
+
+// Run sends reminders for every cart that is currently abandoned.
+func (j *AbandonedCartJob) Run(ctx context.Context) error {
+	rows, err := j.DB.QueryContext(ctx, `
+		SELECT c.id, c.user_id IS NULL, COALESCE(u.email, c.email, ''), COALESCE(c.phone_number, ''), COALESCE(u.full_name, ''),
+			SUM(ci.quantity), SUM(ci.quantity * p.price)
+		FROM carts c
+		JOIN cart_items ci ON ci.cart_id = c.id
+		JOIN products p ON p.id = ci.product_id
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE c.updated_at < NOW() - make_interval(secs => $1)
+			AND c.updated_at > NOW() - make_interval(secs => $2)
+			AND COALESCE(u.email, c.email, c.phone_number) IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM cart_reminders r WHERE r.cart_id = c.id AND r.sent_at >= c.updated_at)
+		GROUP BY c.id, u.email, u.full_name
+		LIMIT 100`,
+		j.IdleAfter.Seconds(), abandonedCartMaxAge.Seconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("find abandoned carts: %w", err)
+	}
+	var carts []abandonedCart
+	for rows.Next() {
+		var ac abandonedCart
+		if err := rows.Scan(&ac.ID, &ac.IsGuest, &ac.Email, &ac.Phone, &ac.Name, &ac.ItemCount, &ac.Value); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan abandoned cart: %w", err)
+		}
+		carts = append(carts, ac)
+	}
+	rows.Close()
+
+	for _, ac := range carts {
+		if err := j.remind(ctx, ac); err != nil {
+			return fmt.Errorf("remind cart %s: %w", ac.ID, err)
+		}
+	}
+	return nil
+}
+
+// remind records a reminder for the cart and sends it. The record is removed
+// again if delivery fails so the next run retries.
+func (j *AbandonedCartJob) remind(ctx context.Context, ac abandonedCart) error {
+	msg := notifications.Message{Channel: notifications.ChannelEmail, To: ac.Email}
+	if ac.Email == "" {
+		msg.Channel, msg.To = notifications.ChannelSMS, ac.Phone
+	}
+
+	var reminderID string
+	err := j.DB.QueryRowContext(ctx,
+		`INSERT INTO cart_reminders (cart_id, channel, recipient, cart_value) VALUES ($1, $2, $3, $4) RETURNING id`,
+		ac.ID, msg.Channel, msg.To, ac.Value,
+	).Scan(&reminderID)
+	if err != nil {
+		return err
+	}
+
+	msg.Link = j.deepLink(ac, reminderID)
+	msg.Subject = "You left something in your cart"
+	greeting := "Hi"
+	if ac.Name != "" {
+		greeting += " " + ac.Name
+	}
+	msg.Body = fmt.Sprintf("%s, your cart still has %d item(s) worth KES %.2f waiting for you.", greeting, ac.ItemCount, ac.Value)
+
+	if err := j.Notifier.Notify(ctx, msg); err != nil {
+		j.DB.ExecContext(ctx, `DELETE FROM cart_reminders WHERE id = $1`, reminderID)
+		return err
+	}
+	return nil
+}
+
+// deepLink points at the frontend cart page. Guest links carry the signed cart
+// token so the cart can be restored on another device.
+func (j *AbandonedCartJob) deepLink(ac abandonedCart, reminderID string) string {
+	q := url.Values{}
+	q.Set("reminder", reminderID)
+	if ac.IsGuest {
+		q.Set("cart_token", services.SignCartToken(ac.ID, j.JWTSecret))
+	}
+	return j.FrontendURL + "/cart?" + q.Encode()
+}
@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ecommerce-backend/internal/notifications"
+
+	"github.com/lib/pq"
+)
+
+// LowStockJob raises an alert when the available stock of a product or
+// variant drops to its low-stock threshold and resolves it once stock is back
+// above. New alerts are sent to admins straight away; Digest sends a summary
+// of everything still low.
+type LowStockJob struct {
+	DB               *sql.DB
+	Notifier         notifications.Notifier
+	DefaultThreshold int
+	DigestInterval   time.Duration
+	FrontendURL      string
+}
+
+type lowStockItem struct {
+	Name      string
+	Label     string
+	SKU       string
+	Available int
+	Threshold int
+}
+
+func (i lowStockItem) String() string {
+	name := i.Name
+	if i.Label != "" {
+		name += " (" + i.Label + ")"
+	}
+	if i.SKU != "" {
+		name += " [" + i.SKU + "]"
+	}
+	return fmt.Sprintf("%s: %d available, threshold %d", name, i.Available, i.Threshold)
+}
+
+// Check brings the open alerts in line with current stock and notifies admins
+// of alerts they have not heard about yet. Alerts are retried on the next run
+// only if no admin could be notified.
+func (j *LowStockJob) Check(ctx context.Context) error {
+	// Archived products drop out of inventory_levels, which resolves them too
+	_, err := j.DB.ExecContext(ctx, `
+		UPDATE low_stock_alerts a SET resolved_at = NOW()
+		WHERE a.resolved_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM inventory_levels l
+			WHERE l.product_id = a.product_id AND l.variant_id IS NOT DISTINCT FROM a.variant_id
+				AND l.available <= COALESCE(l.low_stock_threshold, $1)
+		)`, j.DefaultThreshold)
+	if err != nil {
+		return fmt.Errorf("resolve low-stock alerts: %w", err)
+	}
+	res, err := j.DB.ExecContext(ctx, `
+		INSERT INTO low_stock_alerts (product_id, variant_id, threshold, available)
+		SELECT l.product_id, l.variant_id, COALESCE(l.low_stock_threshold, $1), l.available
+		FROM inventory_levels l
+		WHERE l.available <= COALESCE(l.low_stock_threshold, $1)
+		ON CONFLICT DO NOTHING`, j.DefaultThreshold)
+	if err != nil {
+		return fmt.Errorf("raise low-stock alerts: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Raised %d low-stock alerts", n)
+	}
+
+	rows, err := j.DB.QueryContext(ctx, `
+		SELECT a.id, l.name, l.variant_label, l.sku, a.available, a.threshold
+		FROM low_stock_alerts a
+		JOIN inventory_levels l ON l.product_id = a.product_id AND l.variant_id IS NOT DISTINCT FROM a.variant_id
+		WHERE a.resolved_at IS NULL AND a.notified_at IS NULL
+		ORDER BY a.available, l.name`)
+	if err != nil {
+		return fmt.Errorf("find new low-stock alerts: %w", err)
+	}
+	var ids []string
+	var items []lowStockItem
+	for rows.Next() {
+		var id string
+		var item lowStockItem
+		if err := rows.Scan(&id, &item.Name, &item.Label, &item.SKU, &item.Available, &item.Threshold); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan low-stock alert: %w", err)
+		}
+		ids = append(ids, id)
+		items = append(items, item)
+	}
+	rows.Close()
+	if len(items) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("Low stock: %d item(s) need restocking", len(items))
+	if err := j.notifyAdmins(ctx, subject, items); err != nil {
+		return fmt.Errorf("notify low-stock alerts: %w", err)
+	}
+	_, err = j.DB.ExecContext(ctx, `UPDATE low_stock_alerts SET notified_at = NOW() WHERE id = ANY($1::uuid[])`, pq.Array(ids))
+	return err
+}
+
+// Digest sends admins a summary of every open alert with current
+// availability, at most once per DigestInterval. Nothing is sent while stock
+// is healthy, and nothing at all unless DigestInterval is positive.
+func (j *LowStockJob) Digest(ctx context.Context) error {
+	if j.DigestInterval <= 0 {
+		return fmt.Errorf("digest interval %s is not positive", j.DigestInterval)
+	}
+	var due bool
+	err := j.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(sent_at), '-infinity') <= NOW() - make_interval(secs => $1) FROM low_stock_digests`,
+		j.DigestInterval.Seconds(),
+	).Scan(&due)
+	if err != nil || !due {
+		return err
+	}
+
+	rows, err := j.DB.QueryContext(ctx, `
+		SELECT l.name, l.variant_label, l.sku, l.available, COALESCE(l.low_stock_threshold, $1)
+		FROM low_stock_alerts a
+		JOIN inventory_levels l ON l.product_id = a.product_id AND l.variant_id IS NOT DISTINCT FROM a.variant_id
+		WHERE a.resolved_at IS NULL
+		ORDER BY l.available, l.name, l.variant_label`, j.DefaultThreshold)
+	if err != nil {
+		return fmt.Errorf("load low-stock digest: %w", err)
+	}
+	var items []lowStockItem
+	for rows.Next() {
+		var item lowStockItem
+		if err := rows.Scan(&item.Name, &item.Label, &item.SKU, &item.Available, &item.Threshold); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan low-stock digest: %w", err)
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if len(items) == 0 {
+		return nil
+	}
+
+	subject := fmt.Sprintf("Low-stock digest: %d item(s) below threshold", len(items))
+	if err := j.notifyAdmins(ctx, subject, items); err != nil {
+		return fmt.Errorf("send low-stock digest: %w", err)
+	}
+	_, err = j.DB.ExecContext(ctx, `INSERT INTO low_stock_digests (alert_count) VALUES ($1)`, len(items))
+	return err
+}
+
+// notifyAdmins emails every admin a list of items. A failed send is logged
+// and skipped so the other admins still hear about it; an error is returned
+// only when nobody could be reached, so the caller retries later instead of
+// repeating the alert to admins who already got it.
+func (j *LowStockJob) notifyAdmins(ctx context.Context, subject string, items []lowStockItem) error {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = "- " + item.String()
+	}
+	body := strings.Join(lines, "\n")
+
+	rows, err := j.DB.QueryContext(ctx, `SELECT email FROM users WHERE role = 'admin' ORDER BY email`)
+	if err != nil {
+		return err
+	}
+	var recipients []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			rows.Close()
+			return err
+		}
+		recipients = append(recipients, email)
+	}
+	rows.Close()
+	if len(recipients) == 0 {
+		log.Printf("%s (no admins to notify)\n%s", subject, body)
+		return nil
+	}
+	var sent int
+	var lastErr error
+	for _, to := range recipients {
+		msg := notifications.Message{
+			Channel: notifications.ChannelEmail,
+			To:      to,
+			Subject: subject,
+			Body:    body,
+			Link:    j.FrontendURL + "/admin",
+		}
+		if err := j.Notifier.Notify(ctx, msg); err != nil {
+			log.Printf("Failed to send %q to %s: %v", subject, to, err)
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return lastErr
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// ExpireLoyaltyPoints writes off points whose earn entries have passed their
+// expiry date. Points are treated as spent oldest first, so only the part of
+// an expired lot not already covered by redemptions and earlier expiries is
+// removed, and never more than the current balance. Lots whose order was
+// reversed are ignored since the reversal already took them back.
+func ExpireLoyaltyPoints(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Lock the affected users so this does not race checkout redemptions
+		_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id IN (
+			SELECT DISTINCT user_id FROM loyalty_points_ledger WHERE entry_type = 'earn' AND expires_at <= NOW()
+		) ORDER BY id FOR UPDATE`)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO loyalty_points_ledger (user_id, points, entry_type)
+			SELECT user_id, -LEAST(expired - consumed, balance), 'expire'
+			FROM (
+				SELECT l.user_id,
+					COALESCE(SUM(l.points) FILTER (WHERE l.entry_type = 'earn' AND l.expires_at <= NOW()
+						AND NOT EXISTS (SELECT 1 FROM loyalty_points_ledger r WHERE r.order_id = l.order_id AND r.entry_type = 'reversal')), 0) AS expired,
+					COALESCE(-SUM(l.points) FILTER (WHERE l.entry_type IN ('redeem', 'refund', 'expire')), 0) AS consumed,
+					SUM(l.points) AS balance
+				FROM loyalty_points_ledger l
+				GROUP BY l.user_id
+			) t
+			WHERE expired > consumed AND balance > 0`)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Expired loyalty points for %d users", n)
+		}
+		return nil
+	}
+}
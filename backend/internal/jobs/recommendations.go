@@ -0,0 +1,15 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+)
+
+// RefreshCoPurchases recomputes the co-purchase counts behind "frequently
+// bought together" recommendations.
+func RefreshCoPurchases(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY product_co_purchases`)
+		return err
+	}
+}
@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// ReleaseExpiredReservations marks reservations whose TTL has passed as
+// released. Availability already ignores expired holds, so this only keeps
+// the table tidy and the status accurate.
+func ReleaseExpiredReservations(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		res, err := db.ExecContext(ctx, `UPDATE stock_reservations SET status = 'released', updated_at = NOW() WHERE status = 'active' AND expires_at <= NOW()`)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Released %d expired stock reservations", n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,203 @@
+// Package media turns uploaded files into safe, web-ready image renditions.
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"ecommerce-backend/internal/storage"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	// Decoders accepted for uploads
+	_ "image/gif"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrTooLarge    = errors.New("image is too large")
+	ErrUnsupported = errors.New("file is not a supported image (JPEG, PNG, GIF or WebP)")
+)
+
+// Uploads whose decoded size exceeds this many pixels are rejected before
+// decoding, so small files cannot expand into huge bitmaps.
+const maxPixels = 40_000_000
+
+const jpegQuality = 85
+
+// Rendition sizes, as the longest side in pixels. Images are never upscaled.
+var renditionSizes = []struct {
+	Name string
+	Size int
+}{
+	{"thumbnail", 200},
+	{"medium", 600},
+	{"large", 1200},
+}
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Image describes a processed upload. Renditions maps rendition names
+// (original, thumbnail, medium, large and their _webp counterparts) to public
+// URLs; URL is the large rendition.
+type Image struct {
+	URL        string            `json:"url"`
+	Renditions map[string]string `json:"renditions"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+}
+
+// Processor validates uploads and writes their renditions to store. The URLs
+// it returns are baseURL followed by the object key, so they stay valid
+// whichever way the store serves objects.
+type Processor struct {
+	store    storage.Storage
+	baseURL  string
+	maxBytes int64
+}
+
+func NewProcessor(store storage.Storage, baseURL string, maxBytes int64) *Processor {
+	return &Processor{store: store, baseURL: strings.TrimRight(baseURL, "/"), maxBytes: maxBytes}
+}
+
+// MaxBytes is the largest upload accepted.
+func (p *Processor) MaxBytes() int64 {
+	return p.maxBytes
+}
+
+// ProcessFile processes a multipart upload.
+func (p *Processor) ProcessFile(ctx context.Context, fh *multipart.FileHeader) (*Image, error) {
+	if fh.Size > p.maxBytes {
+		return nil, ErrTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return p.Process(ctx, f)
+}
+
+// Process sniffs and decodes r, re-encodes it without metadata and writes the
+// original and resized renditions under a content-hash name. The client's
+// filename is never used, so uploads cannot escape the store or overwrite
+// other images; uploading the same bytes twice reuses the same objects.
+func (p *Processor) Process(ctx context.Context, r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, p.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > p.maxBytes {
+		return nil, ErrTooLarge
+	}
+	if !allowedTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupported
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	// Re-encoding drops EXIF, so apply its orientation to the pixels first
+	src = applyOrientation(src, jpegOrientation(data))
+
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16])
+	b := src.Bounds()
+	img := &Image{Renditions: map[string]string{}, Width: b.Dx(), Height: b.Dy()}
+
+	if img.Renditions["original"], err = p.write(ctx, name, src); err != nil {
+		return nil, err
+	}
+	for _, r := range renditionSizes {
+		resized := fit(src, r.Size)
+		if img.Renditions[r.Name], err = p.write(ctx, name+"_"+r.Name, resized); err != nil {
+			return nil, err
+		}
+		if img.Renditions[r.Name+"_webp"], err = p.writeWebP(ctx, name+"_"+r.Name, resized); err != nil {
+			return nil, err
+		}
+	}
+	img.URL = img.Renditions["large"]
+	return img, nil
+}
+
+// write encodes img as PNG when it has transparency and JPEG otherwise.
+func (p *Processor) write(ctx context.Context, name string, img image.Image) (string, error) {
+	if isOpaque(img) {
+		return p.save(ctx, name+".jpg", "image/jpeg", func(w io.Writer) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+		})
+	}
+	return p.save(ctx, name+".png", "image/png", func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+func (p *Processor) writeWebP(ctx context.Context, name string, img image.Image) (string, error) {
+	return p.save(ctx, name+".webp", "image/webp", func(w io.Writer) error {
+		return nativewebp.Encode(w, img, nil)
+	})
+}
+
+// save stores an object and returns its public URL. Objects are named by
+// content, so an existing object is already correct and is kept.
+func (p *Processor) save(ctx context.Context, key, contentType string, encode func(io.Writer) error) (string, error) {
+	url := p.baseURL + "/" + key
+	if exists, err := p.store.Exists(ctx, key); err != nil {
+		return "", err
+	} else if exists {
+		return url, nil
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return "", fmt.Errorf("encode %s: %w", key, err)
+	}
+	return url, p.store.Put(ctx, key, buf.Bytes(), contentType)
+}
+
+// fit scales img so its longest side is at most size.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
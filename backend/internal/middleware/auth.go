@@ -51,3 +51,19 @@ func AdminRequired() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// OptionalAuth stores user info in the context when a valid bearer token is
+// present and lets the request through unauthenticated otherwise.
+func OptionalAuth(jwtSecret string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenStr := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if tokenStr == "" {
+			return c.Next()
+		}
+		if claims, err := services.ValidateJWT(tokenStr, jwtSecret); err == nil {
+			c.Locals("user_id", claims.UserID)
+			c.Locals("role", claims.Role)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Cart struct {
+	ID          uuid.UUID          `json:"id"`
+	Items       []CartItem         `json:"items"`
+	ItemCount   int                `json:"item_count"`
+	Subtotal    float64            `json:"subtotal"`
+	Discount    float64            `json:"discount"`
+	Total       float64            `json:"total"`
+	Promotions  []AppliedPromotion `json:"promotions"`
+	CouponCode  string             `json:"coupon_code,omitempty"`
+	CouponError string             `json:"coupon_error,omitempty"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	GuestToken  string             `json:"guest_token,omitempty"`
+}
+
+type CartItem struct {
+	ID           uuid.UUID  `json:"id"`
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	SKU          string     `json:"sku,omitempty"`
+	VariantLabel string     `json:"variant_label,omitempty"`
+	Name         string     `json:"name"`
+	ImageURL     string     `json:"image_url"`
+	UnitPrice    float64    `json:"unit_price"`
+	Quantity     int        `json:"quantity"`
+	Stock        int        `json:"stock"`
+	InStock      bool       `json:"in_stock"`
+	Discount     float64    `json:"discount"`
+	LineTotal    float64    `json:"line_total"`
+}
+
+type AddCartItemRequest struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id"`
+	Quantity  int        `json:"quantity"`
+}
+
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+// CheckoutRequest places an order for the cart. Orders collected from a shop
+// set PickupLocationID and may leave out the shipping address.
+type CheckoutRequest struct {
+	ShippingAddress  string     `json:"shipping_address"`
+	PickupLocationID *uuid.UUID `json:"pickup_location_id"`
+	PhoneNumber      string     `json:"phone_number"`
+	Email            string     `json:"email"`
+	CouponCode       string     `json:"coupon_code"`
+	GiftCardCode     string     `json:"gift_card_code"`
+	UseStoreCredit   bool       `json:"use_store_credit"`
+	LoyaltyPoints    int        `json:"loyalty_points"`
+}
+
+type CartContactRequest struct {
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+type AbandonedCartReport struct {
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	RemindersSent    int       `json:"reminders_sent"`
+	CartsRecovered   int       `json:"carts_recovered"`
+	RecoveryRate     float64   `json:"recovery_rate"`
+	RemindedValue    float64   `json:"reminded_value"`
+	RecoveredRevenue float64   `json:"recovered_revenue"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Category struct {
+	ID           uuid.UUID  `json:"id"`
+	ParentID     *uuid.UUID `json:"parent_id"`
+	Name         string     `json:"name"`
+	Slug         string     `json:"slug"`
+	Description  string     `json:"description"`
+	ImageURL     string     `json:"image_url"`
+	Position     int        `json:"position"`
+	IsActive     bool       `json:"is_active"`
+	ProductCount int        `json:"product_count"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Children     []Category `json:"children,omitempty"`
+}
+
+type CategoryRequest struct {
+	ParentID    *uuid.UUID `json:"parent_id"`
+	Name        string     `json:"name"`
+	Slug        string     `json:"slug"`
+	Description string     `json:"description"`
+	ImageURL    string     `json:"image_url"`
+	Position    int        `json:"position"`
+	IsActive    *bool      `json:"is_active"`
+}
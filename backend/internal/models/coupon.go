@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Coupon struct {
+	ID             uuid.UUID   `json:"id"`
+	Code           string      `json:"code"`
+	Description    string      `json:"description"`
+	DiscountType   string      `json:"discount_type"`
+	DiscountValue  float64     `json:"discount_value"`
+	MinOrderAmount float64     `json:"min_order_amount"`
+	ProductIDs     []uuid.UUID `json:"product_ids"`
+	Categories     []string    `json:"categories"`
+	StartsAt       *time.Time  `json:"starts_at"`
+	EndsAt         *time.Time  `json:"ends_at"`
+	UsageLimit     *int        `json:"usage_limit"`
+	PerUserLimit   *int        `json:"per_user_limit"`
+	TimesUsed      int         `json:"times_used"`
+	IsActive       bool        `json:"is_active"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+type CouponRequest struct {
+	Code           string      `json:"code"`
+	Description    string      `json:"description"`
+	DiscountType   string      `json:"discount_type"`
+	DiscountValue  float64     `json:"discount_value"`
+	MinOrderAmount float64     `json:"min_order_amount"`
+	ProductIDs     []uuid.UUID `json:"product_ids"`
+	Categories     []string    `json:"categories"`
+	StartsAt       *time.Time  `json:"starts_at"`
+	EndsAt         *time.Time  `json:"ends_at"`
+	UsageLimit     *int        `json:"usage_limit"`
+	PerUserLimit   *int        `json:"per_user_limit"`
+	IsActive       *bool       `json:"is_active"`
+}
+
+type ApplyCouponRequest struct {
+	Code string `json:"code"`
+}
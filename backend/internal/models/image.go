@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProductImage struct {
+	ID        uuid.UUID `json:"id"`
+	ProductID uuid.UUID `json:"product_id"`
+	URL       string    `json:"url"`
+	// Renditions maps original, thumbnail, medium and large (plus their
+	// _webp counterparts) to public URLs. URL is the large rendition.
+	Renditions map[string]string `json:"renditions"`
+	AltText    string            `json:"alt_text"`
+	Position   int               `json:"position"`
+	IsPrimary  bool              `json:"is_primary"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type ReorderImagesRequest struct {
+	ImageIDs []uuid.UUID `json:"image_ids"`
+}
+
+type UpdateImageRequest struct {
+	AltText   *string `json:"alt_text"`
+	IsPrimary *bool   `json:"is_primary"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type StockMovement struct {
+	ID           uuid.UUID  `json:"id"`
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	LocationID   uuid.UUID  `json:"location_id"`
+	LocationName string     `json:"location_name"`
+	Quantity     int        `json:"quantity"`
+	MovementType string     `json:"movement_type"`
+	Reason       string     `json:"reason"`
+	OrderID      *uuid.UUID `json:"order_id,omitempty"`
+	TransferID   *uuid.UUID `json:"transfer_id,omitempty"`
+	ActorID      *uuid.UUID `json:"actor_id,omitempty"`
+	ActorName    string     `json:"actor_name,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// StockAdjustmentRequest records a manual stock change at a location, the
+// default location when LocationID is not set. Quantity is the change,
+// negative to remove stock; restocks and returns must add stock.
+type StockAdjustmentRequest struct {
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	LocationID   *uuid.UUID `json:"location_id,omitempty"`
+	Quantity     int        `json:"quantity"`
+	MovementType string     `json:"movement_type"`
+	Reason       string     `json:"reason"`
+	OrderID      *uuid.UUID `json:"order_id,omitempty"`
+}
+
+// StockHistoryResponse lists movements newest first. LedgerStock is the sum
+// of all movements and always equals Stock, which is the stock at LocationID
+// when the history is for one location.
+type StockHistoryResponse struct {
+	ProductID   uuid.UUID       `json:"product_id"`
+	VariantID   *uuid.UUID      `json:"variant_id,omitempty"`
+	LocationID  *uuid.UUID      `json:"location_id,omitempty"`
+	Stock       int             `json:"stock"`
+	LedgerStock int             `json:"ledger_stock"`
+	Movements   []StockMovement `json:"movements"`
+	Total       int             `json:"total"`
+	Page        int             `json:"page"`
+	Limit       int             `json:"limit"`
+}
+
+// LowStockAlert is raised when available stock drops to the threshold.
+// Available is the current availability and is nil when the product or
+// variant is no longer sold.
+type LowStockAlert struct {
+	ID               uuid.UUID  `json:"id"`
+	ProductID        uuid.UUID  `json:"product_id"`
+	VariantID        *uuid.UUID `json:"variant_id,omitempty"`
+	ProductName      string     `json:"product_name"`
+	VariantLabel     string     `json:"variant_label,omitempty"`
+	SKU              string     `json:"sku,omitempty"`
+	Threshold        int        `json:"threshold"`
+	AvailableAtAlert int        `json:"available_at_alert"`
+	Available        *int       `json:"available"`
+	NotifiedAt       *time.Time `json:"notified_at,omitempty"`
+	AcknowledgedAt   *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy   *uuid.UUID `json:"acknowledged_by,omitempty"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type LowStockAlertsResponse struct {
+	Alerts []LowStockAlert `json:"alerts"`
+	Total  int             `json:"total"`
+	Page   int             `json:"page"`
+	Limit  int             `json:"limit"`
+}
+
+type LowStockItem struct {
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	ProductName  string     `json:"product_name"`
+	VariantLabel string     `json:"variant_label,omitempty"`
+	SKU          string     `json:"sku,omitempty"`
+	Stock        int        `json:"stock"`
+	Available    int        `json:"available"`
+	Threshold    int        `json:"threshold"`
+	AlertID      *uuid.UUID `json:"alert_id,omitempty"`
+}
+
+// LowStockDigest lists everything at or below its threshold right now.
+type LowStockDigest struct {
+	GeneratedAt      time.Time      `json:"generated_at"`
+	DefaultThreshold int            `json:"default_threshold"`
+	Items            []LowStockItem `json:"items"`
+}
+
+// LowStockThresholdRequest sets a product's threshold; null restores the
+// default.
+type LowStockThresholdRequest struct {
+	LowStockThreshold *int `json:"low_stock_threshold"`
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Location is a warehouse or shop that holds stock.
+type Location struct {
+	ID            uuid.UUID `json:"id"`
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	LocationType  string    `json:"location_type"`
+	Address       string    `json:"address"`
+	City          string    `json:"city"`
+	Latitude      float64   `json:"latitude"`
+	Longitude     float64   `json:"longitude"`
+	Priority      int       `json:"priority"`
+	PickupEnabled bool      `json:"pickup_enabled"`
+	IsDefault     bool      `json:"is_default"`
+	IsActive      bool      `json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LocationRequest creates or replaces a location. Leaving is_default out
+// keeps the location's current default flag; only an explicit false clears
+// it.
+type LocationRequest struct {
+	Code          string   `json:"code"`
+	Name          string   `json:"name"`
+	LocationType  string   `json:"location_type"`
+	Address       string   `json:"address"`
+	City          string   `json:"city"`
+	Latitude      *float64 `json:"latitude"`
+	Longitude     *float64 `json:"longitude"`
+	Priority      *int     `json:"priority"`
+	PickupEnabled bool     `json:"pickup_enabled"`
+	IsDefault     *bool    `json:"is_default"`
+	IsActive      *bool    `json:"is_active"`
+}
+
+// LocationStockItem is how much of a product or variant a location holds.
+type LocationStockItem struct {
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	ProductName  string     `json:"product_name"`
+	VariantLabel string     `json:"variant_label,omitempty"`
+	SKU          string     `json:"sku,omitempty"`
+	Quantity     int        `json:"quantity"`
+}
+
+type LocationStockResponse struct {
+	Location Location            `json:"location"`
+	Items    []LocationStockItem `json:"items"`
+	Total    int                 `json:"total"`
+	Page     int                 `json:"page"`
+	Limit    int                 `json:"limit"`
+}
+
+// LocationAvailability is the stock one location holds of a product.
+type LocationAvailability struct {
+	LocationID    uuid.UUID `json:"location_id"`
+	Name          string    `json:"name"`
+	LocationType  string    `json:"location_type"`
+	City          string    `json:"city"`
+	PickupEnabled bool      `json:"pickup_enabled"`
+	Quantity      int       `json:"quantity"`
+}
+
+// ProductAvailability is a product's (or variant's) availability across all
+// locations together with what each location holds. Available excludes
+// stock held for unpaid orders; the per-location quantities do not.
+type ProductAvailability struct {
+	ProductID uuid.UUID              `json:"product_id"`
+	VariantID *uuid.UUID             `json:"variant_id,omitempty"`
+	Stock     int                    `json:"stock"`
+	Available int                    `json:"available"`
+	Locations []LocationAvailability `json:"locations"`
+}
+
+type StockTransfer struct {
+	ID             uuid.UUID           `json:"id"`
+	FromLocationID uuid.UUID           `json:"from_location_id"`
+	FromLocation   string              `json:"from_location"`
+	ToLocationID   uuid.UUID           `json:"to_location_id"`
+	ToLocation     string              `json:"to_location"`
+	Status         string              `json:"status"`
+	Note           string              `json:"note"`
+	CreatedBy      *uuid.UUID          `json:"created_by,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	CompletedAt    *time.Time          `json:"completed_at,omitempty"`
+	Items          []StockTransferItem `json:"items"`
+}
+
+type StockTransferItem struct {
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	ProductName  string     `json:"product_name,omitempty"`
+	VariantLabel string     `json:"variant_label,omitempty"`
+	Quantity     int        `json:"quantity"`
+}
+
+// StockTransferRequest sends stock from one location to another. The stock
+// leaves the source straight away and arrives when the transfer is received.
+type StockTransferRequest struct {
+	FromLocationID uuid.UUID           `json:"from_location_id"`
+	ToLocationID   uuid.UUID           `json:"to_location_id"`
+	Note           string              `json:"note"`
+	Items          []StockTransferItem `json:"items"`
+}
+
+// OrderAllocation is the part of an order shipped from one location.
+type OrderAllocation struct {
+	LocationID   uuid.UUID  `json:"location_id"`
+	LocationName string     `json:"location_name"`
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	Quantity     int        `json:"quantity"`
+}
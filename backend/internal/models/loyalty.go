@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LoyaltyEntry struct {
+	ID        uuid.UUID  `json:"id"`
+	Points    int        `json:"points"`
+	EntryType string     `json:"entry_type"`
+	OrderID   *uuid.UUID `json:"order_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type LoyaltyResponse struct {
+	Balance    int            `json:"balance"`
+	PointValue float64        `json:"point_value"`
+	Value      float64        `json:"value"`
+	Entries    []LoyaltyEntry `json:"entries"`
+}
@@ -20,34 +20,80 @@ type MpesaSTKPushResponse struct {
 	CreatedAt     time.Time `json:"created_at"`
 }
 
+type MpesaWebhookRequest struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	Status        string    `json:"status"`
+	Amount        float64   `json:"amount"`
+	Phone         string    `json:"phone"`
+}
+
+// PaymentReview is a successful M-Pesa payment that could not be applied to
+// its order and needs a refund or manual fulfilment.
+type PaymentReview struct {
+	TransactionID uuid.UUID  `json:"transaction_id"`
+	MpesaRef      string     `json:"mpesa_ref"`
+	OrderID       uuid.UUID  `json:"order_id"`
+	OrderNumber   string     `json:"order_number"`
+	OrderStatus   string     `json:"order_status"`
+	Amount        float64    `json:"amount"`
+	PhoneNumber   string     `json:"phone_number"`
+	Reason        string     `json:"reason"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
 type Order struct {
-	ID          uuid.UUID   `json:"id"`
-	UserID      uuid.UUID   `json:"user_id"`
-	UserName    string      `json:"user_name"`
-	Status      string      `json:"status"`
-	TotalAmount float64     `json:"total_amount"`
-	CreatedAt   time.Time   `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
-	Items       []OrderItem `json:"items"`
+	ID                    uuid.UUID          `json:"id"`
+	OrderNumber           string             `json:"order_number"`
+	UserID                *uuid.UUID         `json:"user_id"`
+	UserName              string             `json:"user_name"`
+	GuestEmail            string             `json:"guest_email,omitempty"`
+	Status                string             `json:"status"`
+	SubtotalAmount        float64            `json:"subtotal_amount"`
+	DiscountAmount        float64            `json:"discount_amount"`
+	CouponCode            string             `json:"coupon_code,omitempty"`
+	LoyaltyPointsRedeemed int                `json:"loyalty_points_redeemed"`
+	LoyaltyDiscount       float64            `json:"loyalty_discount"`
+	TotalAmount           float64            `json:"total_amount"`
+	GiftCardAmount        float64            `json:"gift_card_amount"`
+	StoreCreditAmount     float64            `json:"store_credit_amount"`
+	AmountDue             float64            `json:"amount_due"`
+	PickupLocationID      *uuid.UUID         `json:"pickup_location_id,omitempty"`
+	FulfillmentLocationID *uuid.UUID         `json:"fulfillment_location_id,omitempty"`
+	CreatedAt             time.Time          `json:"created_at"`
+	UpdatedAt             time.Time          `json:"updated_at"`
+	Items                 []OrderItem        `json:"items"`
+	Promotions            []AppliedPromotion `json:"promotions,omitempty"`
+	Allocations           []OrderAllocation  `json:"allocations,omitempty"`
 }
 
 type OrderItem struct {
-	ID        uuid.UUID `json:"id"`
-	OrderID   uuid.UUID `json:"order_id"`
-	ProductID uuid.UUID `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	Product   *Product  `json:"product,omitempty"`
+	ID           uuid.UUID  `json:"id"`
+	OrderID      uuid.UUID  `json:"order_id"`
+	ProductID    uuid.UUID  `json:"product_id"`
+	VariantID    *uuid.UUID `json:"variant_id,omitempty"`
+	SKU          string     `json:"sku,omitempty"`
+	VariantLabel string     `json:"variant_label,omitempty"`
+	Quantity     int        `json:"quantity"`
+	Price        float64    `json:"price"`
+	Discount     float64    `json:"discount"`
+	Product      *Product   `json:"product,omitempty"`
 }
 
 type OrderRequest struct {
 	Items []struct {
-		ProductID uuid.UUID `json:"product_id"`
-		Quantity  int       `json:"quantity"`
-		UnitPrice float64   `json:"unit_price"`
+		ProductID uuid.UUID  `json:"product_id"`
+		VariantID *uuid.UUID `json:"variant_id"`
+		Quantity  int        `json:"quantity"`
+		UnitPrice float64    `json:"unit_price"`
 	} `json:"items"`
-	ShippingAddress string `json:"shipping_address"`
-	PhoneNumber     string `json:"phone_number"`
+	ShippingAddress  string     `json:"shipping_address"`
+	PickupLocationID *uuid.UUID `json:"pickup_location_id"`
+	PhoneNumber      string     `json:"phone_number"`
+	CouponCode       string     `json:"coupon_code"`
+	GiftCardCode     string     `json:"gift_card_code"`
+	UseStoreCredit   bool       `json:"use_store_credit"`
+	LoyaltyPoints    int        `json:"loyalty_points"`
 }
 
 type UpdateOrderStatusRequest struct {
@@ -7,16 +7,26 @@ import (
 )
 
 type Product struct {
-	ID          uuid.UUID `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	Price       float64   `json:"price" db:"price"`
-	Stock       int       `json:"stock" db:"stock"`
-	Category    string    `json:"category" db:"category"`
-	ImageURL    string    `json:"image_url" db:"image_url"`
-	IsActive    bool      `json:"is_active" db:"is_active"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID             uuid.UUID           `json:"id" db:"id"`
+	SKU            string              `json:"sku,omitempty" db:"sku"`
+	Name           string              `json:"name" db:"name"`
+	Description    string              `json:"description" db:"description"`
+	Price          float64             `json:"price" db:"price"`
+	EffectivePrice float64             `json:"effective_price" db:"-"`
+	Stock          int                 `json:"stock" db:"stock"`
+	AvailableStock int                 `json:"available_stock" db:"-"`
+	Category       string              `json:"category" db:"category"`
+	CategoryID     *uuid.UUID          `json:"category_id" db:"category_id"`
+	ImageURL       string              `json:"image_url" db:"image_url"`
+	IsActive       bool                `json:"is_active" db:"is_active"`
+	RatingAverage  float64             `json:"rating_average" db:"rating_average"`
+	RatingCount    int                 `json:"rating_count" db:"rating_count"`
+	CreatedAt      time.Time           `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at" db:"updated_at"`
+	Promotions     []AppliedPromotion  `json:"promotions,omitempty" db:"-"`
+	Options        []ProductOptionType `json:"options,omitempty" db:"-"`
+	Variants       []ProductVariant    `json:"variants,omitempty" db:"-"`
+	Images         []ProductImage      `json:"images" db:"-"`
 }
 
 type ProductRequest struct {
@@ -27,9 +37,70 @@ type ProductRequest struct {
 	Category    string  `json:"category"`
 }
 
+// ProductUpdateRequest changes the fields that are set and leaves the rest
+// alone. It is accepted as JSON or multipart form data.
+type ProductUpdateRequest struct {
+	SKU         *string  `json:"sku" form:"sku"`
+	Name        *string  `json:"name" form:"name"`
+	Description *string  `json:"description" form:"description"`
+	Price       *float64 `json:"price" form:"price"`
+	Stock       *int     `json:"stock" form:"stock"`
+	Category    *string  `json:"category" form:"category"`
+	CategoryID  *string  `json:"category_id" form:"category_id"`
+}
+
 type ProductsResponse struct {
 	Products []Product `json:"products"`
 	Total    int       `json:"total"`
 	Page     int       `json:"page"`
 	Limit    int       `json:"limit"`
 }
+
+type ProductSearchResult struct {
+	Product
+	Rank        float64 `json:"rank"`
+	NameHTML    string  `json:"name_html"`
+	SnippetHTML string  `json:"snippet_html"`
+}
+
+type ProductSearchResponse struct {
+	Query      string                `json:"query"`
+	DidYouMean string                `json:"did_you_mean,omitempty"`
+	Results    []ProductSearchResult `json:"results"`
+	Total      int                   `json:"total"`
+	Page       int                   `json:"page"`
+	Limit      int                   `json:"limit"`
+}
+
+type SearchSuggestion struct {
+	Type      string     `json:"type"`
+	Text      string     `json:"text"`
+	ProductID *uuid.UUID `json:"product_id,omitempty"`
+}
+
+// ProductImportRow reports what a CSV import did, or would do, with one row.
+type ProductImportRow struct {
+	Row       int        `json:"row"`
+	Action    string     `json:"action,omitempty"`
+	SKU       string     `json:"sku,omitempty"`
+	Name      string     `json:"name"`
+	ProductID *uuid.UUID `json:"product_id,omitempty"`
+	Errors    []string   `json:"errors,omitempty"`
+}
+
+type ProductImportResult struct {
+	DryRun  bool               `json:"dry_run"`
+	Created int                `json:"created"`
+	Updated int                `json:"updated"`
+	Failed  int                `json:"failed"`
+	Rows    []ProductImportRow `json:"rows"`
+}
+
+// Recommendation is a product suggested alongside another. Reason is
+// bought_together, with the number of orders that contained both, or
+// similar for category-based suggestions.
+type Recommendation struct {
+	Product
+	Reason         string `json:"reason"`
+	BoughtTogether int    `json:"bought_together,omitempty"`
+}
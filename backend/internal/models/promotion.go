@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Promotion struct {
+	ID            uuid.UUID   `json:"id"`
+	Name          string      `json:"name"`
+	Description   string      `json:"description"`
+	PromotionType string      `json:"promotion_type"`
+	Value         float64     `json:"value"`
+	ProductIDs    []uuid.UUID `json:"product_ids"`
+	Categories    []string    `json:"categories"`
+	BuyQuantity   *int        `json:"buy_quantity"`
+	GetQuantity   *int        `json:"get_quantity"`
+	Priority      int         `json:"priority"`
+	Stackable     bool        `json:"stackable"`
+	StartsAt      time.Time   `json:"starts_at"`
+	EndsAt        time.Time   `json:"ends_at"`
+	IsActive      bool        `json:"is_active"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+type PromotionRequest struct {
+	Name          string      `json:"name"`
+	Description   string      `json:"description"`
+	PromotionType string      `json:"promotion_type"`
+	Value         float64     `json:"value"`
+	ProductIDs    []uuid.UUID `json:"product_ids"`
+	Categories    []string    `json:"categories"`
+	BuyQuantity   *int        `json:"buy_quantity"`
+	GetQuantity   *int        `json:"get_quantity"`
+	Priority      int         `json:"priority"`
+	Stackable     bool        `json:"stackable"`
+	StartsAt      time.Time   `json:"starts_at"`
+	EndsAt        time.Time   `json:"ends_at"`
+	IsActive      *bool       `json:"is_active"`
+}
+
+type AppliedPromotion struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Discount float64   `json:"discount"`
+}
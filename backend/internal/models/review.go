@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Review struct {
+	ID             uuid.UUID  `json:"id"`
+	ProductID      uuid.UUID  `json:"product_id"`
+	UserID         uuid.UUID  `json:"user_id"`
+	AuthorName     string     `json:"author_name"`
+	OrderID        *uuid.UUID `json:"order_id,omitempty"`
+	Rating         int        `json:"rating"`
+	Title          string     `json:"title"`
+	Body           string     `json:"body"`
+	Status         string     `json:"status"`
+	ModerationNote string     `json:"moderation_note,omitempty"`
+	ModeratedAt    *time.Time `json:"moderated_at,omitempty"`
+	HelpfulCount   int        `json:"helpful_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type ReviewRequest struct {
+	Rating int    `json:"rating"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type ReviewsResponse struct {
+	RatingAverage float64        `json:"rating_average"`
+	RatingCount   int            `json:"rating_count"`
+	Distribution  map[string]int `json:"distribution"`
+	Reviews       []Review       `json:"reviews"`
+	Total         int            `json:"total"`
+	Page          int            `json:"page"`
+	Limit         int            `json:"limit"`
+}
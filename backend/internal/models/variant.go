@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ProductOptionType struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Position int       `json:"position"`
+	Values   []string  `json:"values"`
+}
+
+type ProductVariant struct {
+	ID             uuid.UUID         `json:"id"`
+	ProductID      uuid.UUID         `json:"product_id"`
+	SKU            string            `json:"sku"`
+	Price          *float64          `json:"price"`
+	EffectivePrice float64           `json:"effective_price"`
+	Stock          int               `json:"stock"`
+	AvailableStock int               `json:"available_stock"`
+	Options        map[string]string `json:"options"`
+	Label          string            `json:"label"`
+	ImageURL       string            `json:"image_url"`
+	IsActive       bool              `json:"is_active"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+type ProductOptionsRequest struct {
+	Options []string `json:"options"`
+}
+
+// ProductVariantRequest creates or updates a variant. On update, leaving out
+// price, stock, image_url or is_active keeps the variant's current value.
+type ProductVariantRequest struct {
+	SKU      string            `json:"sku"`
+	Price    *float64          `json:"price"`
+	Stock    *int              `json:"stock"`
+	Options  map[string]string `json:"options"`
+	ImageURL string            `json:"image_url"`
+	IsActive *bool             `json:"is_active"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type GiftCard struct {
+	ID             uuid.UUID  `json:"id"`
+	Code           string     `json:"code"`
+	InitialBalance float64    `json:"initial_balance"`
+	Balance        float64    `json:"balance"`
+	RecipientEmail string     `json:"recipient_email,omitempty"`
+	Message        string     `json:"message,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type IssueGiftCardRequest struct {
+	Amount         float64    `json:"amount"`
+	RecipientEmail string     `json:"recipient_email"`
+	Message        string     `json:"message"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+type PurchaseGiftCardRequest struct {
+	Amount         float64 `json:"amount"`
+	RecipientEmail string  `json:"recipient_email"`
+	Message        string  `json:"message"`
+}
+
+type GiftCardPurchaseResponse struct {
+	GiftCard GiftCard `json:"gift_card"`
+	Order    Order    `json:"order"`
+}
+
+type StoreCreditEntry struct {
+	ID        uuid.UUID  `json:"id"`
+	Amount    float64    `json:"amount"`
+	EntryType string     `json:"entry_type"`
+	OrderID   *uuid.UUID `json:"order_id,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type StoreCreditResponse struct {
+	Balance float64            `json:"balance"`
+	Entries []StoreCreditEntry `json:"entries"`
+}
+
+type StoreCreditAdjustmentRequest struct {
+	Amount    float64    `json:"amount"`
+	EntryType string     `json:"entry_type"`
+	OrderID   *uuid.UUID `json:"order_id"`
+	Note      string     `json:"note"`
+}
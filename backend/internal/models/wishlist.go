@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WishlistItem struct {
+	Product Product   `json:"product"`
+	InStock bool      `json:"in_stock"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type Wishlist struct {
+	Items      []WishlistItem `json:"items"`
+	OwnerName  string         `json:"owner_name,omitempty"`
+	ShareToken string         `json:"share_token,omitempty"`
+	ShareURL   string         `json:"share_url,omitempty"`
+}
+
+type AddWishlistItemRequest struct {
+	ProductID uuid.UUID `json:"product_id"`
+}
+
+type MoveToCartRequest struct {
+	Quantity  int        `json:"quantity"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+}
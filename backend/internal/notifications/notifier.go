@@ -0,0 +1,76 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Channels a message can be delivered on.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+type Message struct {
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	Link    string `json:"link,omitempty"`
+}
+
+// Notifier delivers messages to customers or staff.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// NewNotifier returns the notifier named by kind. "webhook" posts each
+// message as JSON to webhookURL; anything else logs messages.
+func NewNotifier(kind, webhookURL string) Notifier {
+	if kind == "webhook" && webhookURL != "" {
+		return &WebhookNotifier{URL: webhookURL, Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return LogNotifier{}
+}
+
+// LogNotifier writes messages to the server log. It is the default for
+// development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	log.Printf("[NOTIFY] %s to %s: %s - %s %s", msg.Channel, msg.To, msg.Subject, msg.Body, msg.Link)
+	return nil
+}
+
+// WebhookNotifier hands messages to an external delivery service (email/SMS
+// gateway) by posting them as JSON.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// SignCartToken returns a token identifying a guest cart. The cart ID is
+// signed with secret so clients cannot address other guests' carts.
+func SignCartToken(cartID, secret string) string {
+	return cartID + "." + cartTokenSignature(cartID, secret)
+}
+
+// ParseCartToken verifies a token produced by SignCartToken and returns the
+// cart ID it carries.
+func ParseCartToken(token, secret string) (string, error) {
+	cartID, sig, ok := strings.Cut(token, ".")
+	if !ok || cartID == "" {
+		return "", errors.New("malformed cart token")
+	}
+	if !hmac.Equal([]byte(sig), []byte(cartTokenSignature(cartID, secret))) {
+		return "", errors.New("invalid cart token")
+	}
+	return cartID, nil
+}
+
+func cartTokenSignature(cartID, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("cart:" + cartID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+// Package storage stores uploaded files on local disk or in an S3-compatible
+// object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage holds uploaded objects under flat keys such as
+// "3f48cbff84d5_large.jpg".
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL returns a URL the object can be downloaded from right now. It may
+	// be signed and expire, so it should not be persisted.
+	URL(key string) (string, error)
+}
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// New returns the storage backend for driver: "s3" or, by default, the local
+// disk under localDir served at localURL.
+func New(driver, localDir, localURL string, s3 S3Config) (Storage, error) {
+	if driver == "s3" {
+		return NewS3(s3)
+	}
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: localDir, BaseURL: strings.TrimRight(localURL, "/")}, nil
+}
+
+// ValidKey reports whether key is a flat object name that cannot address
+// anything outside the store.
+func ValidKey(key string) bool {
+	if key == "" || key[0] == '.' || len(key) > 200 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Local keeps objects in a directory that the API serves statically. It only
+// suits a single instance or a directory shared between instances.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	// Write to a temporary file and rename it so readers never see a partial
+	// object
+	tmp, err := os.CreateTemp(l.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.Dir, key))
+}
+
+func (l *Local) Exists(ctx context.Context, key string) (bool, error) {
+	if !ValidKey(key) {
+		return false, ErrInvalidKey
+	}
+	_, err := os.Stat(filepath.Join(l.Dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *Local) URL(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return l.BaseURL + "/" + key, nil
+}
@@ -0,0 +1,12 @@
+-- Guest checkout: orders may belong to a guest identified by email
+ALTER TABLE orders ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE orders ADD COLUMN guest_email VARCHAR(255);
+ALTER TABLE orders ADD COLUMN order_number VARCHAR(20);
+ALTER TABLE orders ADD CONSTRAINT orders_customer_check CHECK (user_id IS NOT NULL OR guest_email IS NOT NULL);
+
+-- Backfill order numbers for existing orders
+UPDATE orders SET order_number = 'ORD-' || UPPER(SUBSTRING(REPLACE(id::text, '-', ''), 1, 10)) WHERE order_number IS NULL;
+ALTER TABLE orders ALTER COLUMN order_number SET NOT NULL;
+ALTER TABLE orders ADD CONSTRAINT orders_order_number_key UNIQUE (order_number);
+
+CREATE INDEX idx_orders_guest_email ON orders(LOWER(guest_email));
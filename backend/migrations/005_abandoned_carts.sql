@@ -0,0 +1,18 @@
+-- Contact details captured for guest carts
+ALTER TABLE carts ADD COLUMN email VARCHAR(255);
+ALTER TABLE carts ADD COLUMN phone_number VARCHAR(20);
+
+-- Abandoned cart reminders and their outcome
+CREATE TABLE cart_reminders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    cart_id UUID REFERENCES carts(id) ON DELETE SET NULL,
+    channel VARCHAR(10) NOT NULL CHECK (channel IN ('email', 'sms')),
+    recipient VARCHAR(255) NOT NULL,
+    cart_value DECIMAL(10,2) NOT NULL,
+    sent_at TIMESTAMP DEFAULT NOW(),
+    recovered_order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    recovered_at TIMESTAMP
+);
+
+CREATE INDEX idx_cart_reminders_cart_id ON cart_reminders(cart_id);
+CREATE INDEX idx_cart_reminders_sent_at ON cart_reminders(sent_at);
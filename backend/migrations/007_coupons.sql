@@ -0,0 +1,47 @@
+-- Discount codes
+CREATE TABLE coupons (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL CHECK (discount_type IN ('percentage', 'fixed')),
+    discount_value DECIMAL(10,2) NOT NULL CHECK (discount_value > 0),
+    min_order_amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+    product_ids UUID[] NOT NULL DEFAULT '{}',
+    categories TEXT[] NOT NULL DEFAULT '{}',
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    usage_limit INTEGER CHECK (usage_limit > 0),
+    per_user_limit INTEGER CHECK (per_user_limit > 0),
+    times_used INTEGER NOT NULL DEFAULT 0,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (discount_type <> 'percentage' OR discount_value <= 100)
+);
+
+-- One row per order that used a coupon
+CREATE TABLE coupon_redemptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    coupon_id UUID NOT NULL REFERENCES coupons(id),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id),
+    guest_email VARCHAR(255),
+    discount_amount DECIMAL(10,2) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (coupon_id, order_id)
+);
+
+CREATE INDEX idx_coupon_redemptions_coupon_user ON coupon_redemptions(coupon_id, user_id);
+CREATE INDEX idx_coupon_redemptions_coupon_email ON coupon_redemptions(coupon_id, LOWER(guest_email));
+
+-- Discounts are stored per order line and summed on the order
+ALTER TABLE order_items ADD COLUMN discount_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN subtotal_amount DECIMAL(10,2);
+ALTER TABLE orders ADD COLUMN discount_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN coupon_id UUID REFERENCES coupons(id);
+UPDATE orders SET subtotal_amount = total_amount WHERE subtotal_amount IS NULL;
+ALTER TABLE orders ALTER COLUMN subtotal_amount SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN subtotal_amount SET DEFAULT 0;
+
+-- Code applied to a cart, validated again at checkout
+ALTER TABLE carts ADD COLUMN coupon_code VARCHAR(50);
@@ -0,0 +1,33 @@
+-- Automatic promotions applied without a code
+CREATE TABLE promotions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    promotion_type VARCHAR(20) NOT NULL CHECK (promotion_type IN ('percentage', 'fixed', 'buy_x_get_y', 'bundle')),
+    value DECIMAL(10,2) NOT NULL CHECK (value > 0),
+    product_ids UUID[] NOT NULL DEFAULT '{}',
+    categories TEXT[] NOT NULL DEFAULT '{}',
+    buy_quantity INTEGER CHECK (buy_quantity > 0),
+    get_quantity INTEGER CHECK (get_quantity > 0),
+    priority INTEGER NOT NULL DEFAULT 0,
+    stackable BOOLEAN NOT NULL DEFAULT false,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (ends_at > starts_at),
+    CHECK (promotion_type = 'fixed' OR value <= 100),
+    CHECK (promotion_type <> 'buy_x_get_y' OR (buy_quantity IS NOT NULL AND get_quantity IS NOT NULL)),
+    CHECK (promotion_type <> 'bundle' OR cardinality(product_ids) >= 2)
+);
+
+CREATE INDEX idx_promotions_window ON promotions(starts_at, ends_at) WHERE is_active = true;
+
+-- Promotions that contributed to an order
+CREATE TABLE order_promotions (
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    promotion_id UUID NOT NULL REFERENCES promotions(id),
+    discount_amount DECIMAL(10,2) NOT NULL,
+    PRIMARY KEY (order_id, promotion_id)
+);
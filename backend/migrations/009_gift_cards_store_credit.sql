@@ -0,0 +1,51 @@
+-- Gift cards issued by admins or bought by customers
+CREATE TABLE gift_cards (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(32) UNIQUE NOT NULL,
+    initial_balance DECIMAL(10,2) NOT NULL CHECK (initial_balance > 0),
+    balance DECIMAL(10,2) NOT NULL CHECK (balance >= 0),
+    recipient_email VARCHAR(255),
+    message TEXT,
+    issued_by UUID REFERENCES users(id),
+    purchased_by UUID REFERENCES users(id),
+    purchase_order_id UUID REFERENCES orders(id),
+    expires_at TIMESTAMP,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Append-only history of every gift card balance change
+CREATE TABLE gift_card_transactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    gift_card_id UUID NOT NULL REFERENCES gift_cards(id),
+    order_id UUID REFERENCES orders(id),
+    amount DECIMAL(10,2) NOT NULL,
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('issue', 'redeem', 'refund')),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Append-only store credit ledger; a user's balance is the sum of amounts
+CREATE TABLE store_credit_ledger (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    amount DECIMAL(10,2) NOT NULL CHECK (amount <> 0),
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('refund', 'goodwill', 'adjustment', 'redeem', 'reversal')),
+    order_id UUID REFERENCES orders(id),
+    note TEXT,
+    created_by UUID REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_gift_card_transactions_card ON gift_card_transactions(gift_card_id);
+CREATE INDEX idx_gift_card_transactions_order ON gift_card_transactions(order_id);
+CREATE INDEX idx_store_credit_ledger_user ON store_credit_ledger(user_id, created_at);
+CREATE INDEX idx_store_credit_ledger_order ON store_credit_ledger(order_id);
+
+-- Tenders used on an order and what is left to pay by M-Pesa
+ALTER TABLE orders ADD COLUMN gift_card_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN store_credit_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN amount_due DECIMAL(10,2);
+UPDATE orders SET amount_due = total_amount WHERE amount_due IS NULL;
+ALTER TABLE orders ALTER COLUMN amount_due SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN amount_due SET DEFAULT 0;
@@ -0,0 +1,23 @@
+-- Loyalty points ledger; a user's balance is the sum of points
+CREATE TABLE loyalty_points_ledger (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    points INTEGER NOT NULL CHECK (points <> 0),
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN ('earn', 'redeem', 'refund', 'reversal', 'expire')),
+    order_id UUID REFERENCES orders(id),
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_loyalty_points_ledger_user ON loyalty_points_ledger(user_id, created_at);
+CREATE INDEX idx_loyalty_points_ledger_expiry ON loyalty_points_ledger(expires_at) WHERE entry_type = 'earn';
+-- An order earns points once and has them reversed at most once
+CREATE UNIQUE INDEX idx_loyalty_points_ledger_order_once ON loyalty_points_ledger(order_id, entry_type) WHERE entry_type IN ('earn', 'reversal');
+
+-- Points redeemed as a discount on an order
+ALTER TABLE orders ADD COLUMN loyalty_points_redeemed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN loyalty_discount DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+-- Orders can now be refunded after payment
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN ('pending', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded'));
@@ -0,0 +1,7 @@
+-- Support sorting and filtering of product listings
+CREATE INDEX idx_products_price ON products(price);
+CREATE INDEX idx_products_created_at ON products(created_at DESC);
+CREATE INDEX idx_products_lower_name ON products(LOWER(name));
+CREATE INDEX idx_products_lower_category ON products(LOWER(category));
+-- Popularity counts units sold per product
+CREATE INDEX idx_order_items_product_id ON order_items(product_id);
@@ -0,0 +1,5 @@
+-- Trigram matching for typo-tolerant autocomplete
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_products_name_trgm ON products USING GIN (LOWER(name) gin_trgm_ops);
+CREATE INDEX idx_products_category_trgm ON products USING GIN (LOWER(category) gin_trgm_ops);
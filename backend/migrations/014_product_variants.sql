@@ -0,0 +1,38 @@
+-- Option types a product varies by, such as Size or Colour
+CREATE TABLE product_option_types (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (product_id, name)
+);
+
+-- Sellable variants of a product. options maps option type name to value;
+-- a NULL price means the product price applies.
+CREATE TABLE product_variants (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    sku VARCHAR(64) UNIQUE NOT NULL,
+    price DECIMAL(10,2) CHECK (price > 0),
+    stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    options JSONB NOT NULL DEFAULT '{}',
+    image_url VARCHAR(500),
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_variants_product_id ON product_variants(product_id);
+-- No two active variants of a product share the same options
+CREATE UNIQUE INDEX idx_product_variants_options ON product_variants(product_id, options) WHERE is_active;
+
+-- Cart lines, order items and reservations can name a variant
+ALTER TABLE cart_items ADD COLUMN variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE;
+ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_key;
+CREATE UNIQUE INDEX idx_cart_items_line ON cart_items(cart_id, product_id, (COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'::uuid)));
+
+ALTER TABLE order_items ADD COLUMN variant_id UUID REFERENCES product_variants(id);
+
+ALTER TABLE stock_reservations ADD COLUMN variant_id UUID REFERENCES product_variants(id);
+CREATE INDEX idx_stock_reservations_variant_active ON stock_reservations(variant_id, expires_at) WHERE status = 'active' AND variant_id IS NOT NULL;
@@ -0,0 +1,42 @@
+-- Category tree
+CREATE TABLE categories (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    parent_id UUID REFERENCES categories(id),
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(120) UNIQUE NOT NULL,
+    description TEXT,
+    image_url VARCHAR(500),
+    position INTEGER NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (parent_id IS NULL OR parent_id <> id)
+);
+
+CREATE INDEX idx_categories_parent_id ON categories(parent_id);
+
+-- products.category is kept as the assigned category's name
+ALTER TABLE products ADD COLUMN category_id UUID REFERENCES categories(id);
+CREATE INDEX idx_products_category_id ON products(category_id);
+
+-- Turn the existing free-text categories into top-level categories
+INSERT INTO categories (name, slug)
+SELECT DISTINCT ON (slug) name, slug FROM (
+    SELECT CASE WHEN TRIM(category) = LOWER(TRIM(category)) THEN INITCAP(TRIM(category)) ELSE TRIM(category) END AS name,
+        TRIM(BOTH '-' FROM regexp_replace(LOWER(TRIM(category)), '[^a-z0-9]+', '-', 'g')) AS slug
+    FROM products WHERE COALESCE(TRIM(category), '') <> ''
+) t
+WHERE slug <> ''
+ORDER BY slug, name;
+
+-- Group the seeded electronics under one parent
+INSERT INTO categories (name, slug)
+SELECT 'Electronics', 'electronics'
+WHERE EXISTS (SELECT 1 FROM categories WHERE slug IN ('phones', 'laptops', 'tvs', 'tablets', 'accessories'))
+ON CONFLICT (slug) DO NOTHING;
+UPDATE categories SET parent_id = (SELECT id FROM categories WHERE slug = 'electronics')
+WHERE slug IN ('phones', 'laptops', 'tvs', 'tablets', 'accessories');
+
+UPDATE products p SET category_id = c.id, category = c.name
+FROM categories c
+WHERE c.slug = TRIM(BOTH '-' FROM regexp_replace(LOWER(TRIM(p.category)), '[^a-z0-9]+', '-', 'g'));
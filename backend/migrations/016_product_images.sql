@@ -0,0 +1,16 @@
+-- Ordered image gallery per product; products.image_url mirrors the primary image
+CREATE TABLE product_images (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    url VARCHAR(500) NOT NULL,
+    alt_text VARCHAR(255),
+    position INTEGER NOT NULL DEFAULT 0,
+    is_primary BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_images_product_id ON product_images(product_id, position);
+CREATE UNIQUE INDEX idx_product_images_one_primary ON product_images(product_id) WHERE is_primary;
+
+INSERT INTO product_images (product_id, url, alt_text, position, is_primary)
+SELECT id, image_url, name, 0, true FROM products WHERE COALESCE(image_url, '') <> '';
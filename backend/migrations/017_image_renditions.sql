@@ -0,0 +1,11 @@
+-- Public URLs of each generated rendition of a product image
+ALTER TABLE product_images ADD COLUMN renditions JSONB NOT NULL DEFAULT '{}';
+
+-- Older uploads stored filesystem paths such as ./uploads/shoe.jpg; serve them
+-- from the static /uploads route instead
+UPDATE product_images SET url = '/uploads/' || regexp_replace(url, '^.*/', '')
+WHERE url !~ '^(https?:)?/';
+UPDATE products SET image_url = '/uploads/' || regexp_replace(image_url, '^.*/', '')
+WHERE COALESCE(image_url, '') <> '' AND image_url !~ '^(https?:)?/';
+UPDATE product_variants SET image_url = '/uploads/' || regexp_replace(image_url, '^.*/', '')
+WHERE COALESCE(image_url, '') <> '' AND image_url !~ '^(https?:)?/';
@@ -0,0 +1,2 @@
+-- Optional merchant SKU for products; bulk imports match on it
+ALTER TABLE products ADD COLUMN sku VARCHAR(100) UNIQUE;
@@ -0,0 +1,34 @@
+-- Ratings and reviews from customers with a delivered order for the product
+CREATE TABLE product_reviews (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    title VARCHAR(200),
+    body TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    moderation_note TEXT,
+    moderated_at TIMESTAMP,
+    helpful_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (product_id, user_id)
+);
+
+CREATE INDEX idx_product_reviews_product ON product_reviews(product_id, status, created_at DESC);
+CREATE INDEX idx_product_reviews_status ON product_reviews(status, created_at);
+
+-- One helpful vote per shopper per review
+CREATE TABLE review_votes (
+    review_id UUID NOT NULL REFERENCES product_reviews(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+-- Summary of approved reviews, refreshed whenever one is moderated, edited
+-- or removed
+ALTER TABLE products ADD COLUMN rating_average NUMERIC(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_products_rating ON products(rating_average DESC, rating_count DESC);
@@ -0,0 +1,14 @@
+-- Products customers have saved for later
+CREATE TABLE wishlist_items (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (user_id, product_id)
+);
+
+-- Optional public link to a customer's wishlist; deleting the row revokes it
+CREATE TABLE wishlist_shares (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(32) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
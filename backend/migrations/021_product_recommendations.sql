@@ -0,0 +1,13 @@
+-- How many paid orders contained each pair of products. Refreshed
+-- periodically by the recommendations job; the unique index allows
+-- REFRESH MATERIALIZED VIEW CONCURRENTLY so reads are never blocked.
+CREATE MATERIALIZED VIEW product_co_purchases AS
+SELECT a.product_id, b.product_id AS related_id, COUNT(DISTINCT a.order_id) AS orders_count
+FROM order_items a
+JOIN order_items b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+JOIN orders o ON o.id = a.order_id
+WHERE o.status IN ('paid', 'processing', 'shipped', 'delivered')
+GROUP BY a.product_id, b.product_id;
+
+CREATE UNIQUE INDEX idx_product_co_purchases_pair ON product_co_purchases(product_id, related_id);
+CREATE INDEX idx_product_co_purchases_rank ON product_co_purchases(product_id, orders_count DESC);
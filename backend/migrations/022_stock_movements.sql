@@ -0,0 +1,35 @@
+-- Append-only ledger of every stock change. products.stock (variant_id NULL)
+-- and product_variants.stock always equal the sum of their movements.
+CREATE TABLE stock_movements (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id),
+    variant_id UUID REFERENCES product_variants(id),
+    quantity INTEGER NOT NULL CHECK (quantity <> 0),
+    movement_type VARCHAR(20) NOT NULL CHECK (movement_type IN ('sale', 'cancellation', 'return', 'adjustment', 'restock')),
+    reason TEXT NOT NULL,
+    order_id UUID REFERENCES orders(id),
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_stock_movements_product ON stock_movements(product_id, created_at DESC);
+CREATE INDEX idx_stock_movements_variant ON stock_movements(variant_id, created_at DESC) WHERE variant_id IS NOT NULL;
+CREATE INDEX idx_stock_movements_order ON stock_movements(order_id) WHERE order_id IS NOT NULL;
+
+-- Movements are never edited or removed; corrections are new movements.
+-- Clearing actor_id when a user is deleted is still allowed.
+CREATE FUNCTION stock_movements_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stock_movements_append_only
+BEFORE UPDATE OF product_id, variant_id, quantity, movement_type, reason, order_id, created_at OR DELETE ON stock_movements
+FOR EACH ROW EXECUTE FUNCTION stock_movements_append_only();
+
+-- Opening balances so the ledger explains current stock
+INSERT INTO stock_movements (product_id, quantity, movement_type, reason)
+SELECT id, stock, 'adjustment', 'Opening balance' FROM products WHERE stock <> 0;
+INSERT INTO stock_movements (product_id, variant_id, quantity, movement_type, reason)
+SELECT product_id, id, stock, 'adjustment', 'Opening balance' FROM product_variants WHERE stock <> 0;
@@ -0,0 +1,50 @@
+-- Per-product reorder point. NULL uses the LOW_STOCK_THRESHOLD default.
+ALTER TABLE products ADD COLUMN low_stock_threshold INTEGER CHECK (low_stock_threshold >= 0);
+
+-- Current availability of everything that can be sold: active products
+-- without variants and active variants of active products. Variants share
+-- their product's threshold. Available stock excludes unexpired holds.
+CREATE VIEW inventory_levels AS
+SELECT p.id AS product_id, NULL::uuid AS variant_id, p.name, ''::text AS variant_label, COALESCE(p.sku, '') AS sku,
+    p.stock,
+    p.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+        WHERE r.product_id = p.id AND r.variant_id IS NULL AND r.status = 'active' AND r.expires_at > NOW()), 0) AS available,
+    p.low_stock_threshold
+FROM products p
+WHERE p.is_active AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND v.is_active)
+UNION ALL
+SELECT p.id, v.id, p.name,
+    COALESCE((SELECT string_agg(v.options->>ot.name, ' / ' ORDER BY ot.position) FROM product_option_types ot WHERE ot.product_id = v.product_id), ''),
+    v.sku, v.stock,
+    v.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+        WHERE r.variant_id = v.id AND r.status = 'active' AND r.expires_at > NOW()), 0),
+    p.low_stock_threshold
+FROM product_variants v
+JOIN products p ON p.id = v.product_id
+WHERE p.is_active AND v.is_active;
+
+-- An alert is raised when available stock drops to the threshold and
+-- resolved once it is back above it. Only one alert per product or variant
+-- is open at a time.
+CREATE TABLE low_stock_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id),
+    variant_id UUID REFERENCES product_variants(id),
+    threshold INTEGER NOT NULL,
+    available INTEGER NOT NULL,
+    notified_at TIMESTAMP,
+    acknowledged_at TIMESTAMP,
+    acknowledged_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_low_stock_alerts_open ON low_stock_alerts(product_id, COALESCE(variant_id, product_id)) WHERE resolved_at IS NULL;
+CREATE INDEX idx_low_stock_alerts_created ON low_stock_alerts(created_at DESC);
+
+-- When each digest went out, so restarts do not send extra ones
+CREATE TABLE low_stock_digests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    alert_count INTEGER NOT NULL,
+    sent_at TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,104 @@
+-- Places stock is kept: warehouses and shops. Shops with pickup enabled can
+-- be chosen at checkout. Stock changes without a location go to the default
+-- location.
+CREATE TABLE locations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(32) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    location_type VARCHAR(20) NOT NULL CHECK (location_type IN ('warehouse', 'store')),
+    address TEXT NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 100,
+    pickup_enabled BOOLEAN NOT NULL DEFAULT false,
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CHECK (is_active OR NOT is_default)
+);
+
+CREATE UNIQUE INDEX idx_locations_default ON locations(is_default) WHERE is_default;
+
+INSERT INTO locations (code, name, location_type, city, latitude, longitude, priority, pickup_enabled, is_default) VALUES
+    ('NBO-WH', 'Nairobi Warehouse', 'warehouse', 'Nairobi', -1.2921, 36.8219, 10, false, true),
+    ('MSA-SHOP', 'Mombasa Shop', 'store', 'Mombasa', -4.0435, 39.6682, 20, true, false);
+
+-- Stock on hand per location. products.stock and product_variants.stock are
+-- the totals across locations.
+CREATE TABLE location_stock (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    location_id UUID NOT NULL REFERENCES locations(id),
+    product_id UUID NOT NULL REFERENCES products(id),
+    variant_id UUID REFERENCES product_variants(id),
+    quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_location_stock_item ON location_stock(location_id, product_id, (COALESCE(variant_id, product_id)));
+CREATE INDEX idx_location_stock_product ON location_stock(product_id);
+
+-- Existing stock is all in the warehouse
+INSERT INTO location_stock (location_id, product_id, quantity)
+SELECT l.id, p.id, p.stock FROM products p, locations l WHERE l.is_default AND p.stock > 0;
+INSERT INTO location_stock (location_id, product_id, variant_id, quantity)
+SELECT l.id, v.product_id, v.id, v.stock FROM product_variants v, locations l WHERE l.is_default AND v.stock > 0;
+
+-- Stock sent from one location to another. Stock leaves the source when the
+-- transfer is created and arrives when it is received.
+CREATE TABLE stock_transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    from_location_id UUID NOT NULL REFERENCES locations(id),
+    to_location_id UUID NOT NULL REFERENCES locations(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'in_transit' CHECK (status IN ('in_transit', 'received', 'cancelled')),
+    note TEXT NOT NULL DEFAULT '',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    CHECK (from_location_id <> to_location_id)
+);
+
+CREATE TABLE stock_transfer_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transfer_id UUID NOT NULL REFERENCES stock_transfers(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id),
+    variant_id UUID REFERENCES product_variants(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0)
+);
+
+CREATE INDEX idx_stock_transfers_created ON stock_transfers(created_at DESC);
+CREATE INDEX idx_stock_transfer_items_transfer ON stock_transfer_items(transfer_id);
+
+-- Every movement happens at a location; transfers move stock out of one and
+-- into another.
+ALTER TABLE stock_movements ADD COLUMN location_id UUID REFERENCES locations(id);
+ALTER TABLE stock_movements ADD COLUMN transfer_id UUID REFERENCES stock_transfers(id);
+UPDATE stock_movements SET location_id = (SELECT id FROM locations WHERE is_default);
+ALTER TABLE stock_movements ALTER COLUMN location_id SET NOT NULL;
+ALTER TABLE stock_movements DROP CONSTRAINT stock_movements_movement_type_check;
+ALTER TABLE stock_movements ADD CONSTRAINT stock_movements_movement_type_check
+    CHECK (movement_type IN ('sale', 'cancellation', 'return', 'adjustment', 'restock', 'transfer'));
+CREATE INDEX idx_stock_movements_location ON stock_movements(location_id, created_at DESC);
+
+DROP TRIGGER stock_movements_append_only ON stock_movements;
+CREATE TRIGGER stock_movements_append_only
+BEFORE UPDATE OF product_id, variant_id, quantity, movement_type, reason, order_id, location_id, transfer_id, created_at OR DELETE ON stock_movements
+FOR EACH ROW EXECUTE FUNCTION stock_movements_append_only();
+
+-- Where a paid order ships from. An order is split across locations only
+-- when no single location can fulfil it.
+CREATE TABLE order_allocations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    location_id UUID NOT NULL REFERENCES locations(id),
+    product_id UUID NOT NULL REFERENCES products(id),
+    variant_id UUID REFERENCES product_variants(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_allocations_order ON order_allocations(order_id);
+
+ALTER TABLE orders ADD COLUMN pickup_location_id UUID REFERENCES locations(id);
+ALTER TABLE orders ADD COLUMN fulfillment_location_id UUID REFERENCES locations(id);
@@ -0,0 +1,41 @@
+-- Distinct words in active product names and categories for "did you mean"
+-- corrections, with how many products use each. Kept in step with products
+-- by a trigger so lookups hit the trigram index instead of splitting every
+-- product name.
+CREATE TABLE search_words (
+    word TEXT PRIMARY KEY,
+    product_count INT NOT NULL
+);
+
+CREATE INDEX idx_search_words_trgm ON search_words USING GIN (word gin_trgm_ops);
+
+CREATE FUNCTION product_search_words(name TEXT, category TEXT) RETURNS SETOF TEXT AS $$
+    SELECT DISTINCT word FROM unnest(regexp_split_to_array(LOWER(name || ' ' || COALESCE(category, '')), '[^[:alnum:]]+')) AS word
+    WHERE length(word) > 1
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE FUNCTION search_words_sync() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND COALESCE(OLD.is_active, true) THEN
+        UPDATE search_words SET product_count = product_count - 1
+        WHERE word IN (SELECT product_search_words(OLD.name, OLD.category));
+        DELETE FROM search_words
+        WHERE product_count <= 0 AND word IN (SELECT product_search_words(OLD.name, OLD.category));
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND COALESCE(NEW.is_active, true) THEN
+        INSERT INTO search_words (word, product_count)
+        SELECT word, 1 FROM product_search_words(NEW.name, NEW.category) AS word ORDER BY word
+        ON CONFLICT (word) DO UPDATE SET product_count = search_words.product_count + 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_search_words
+AFTER INSERT OR DELETE OR UPDATE OF name, category, is_active ON products
+FOR EACH ROW EXECUTE FUNCTION search_words_sync();
+
+INSERT INTO search_words (word, product_count)
+SELECT word, COUNT(*) FROM products p, product_search_words(p.name, p.category) AS word
+WHERE COALESCE(p.is_active, true)
+GROUP BY word;
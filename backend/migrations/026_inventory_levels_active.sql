@@ -0,0 +1,20 @@
+-- products.is_active is nullable and NULL means active everywhere else, so
+-- treat it the same way here.
+CREATE OR REPLACE VIEW inventory_levels AS
+SELECT p.id AS product_id, NULL::uuid AS variant_id, p.name, ''::text AS variant_label, COALESCE(p.sku, '') AS sku,
+    p.stock,
+    p.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+        WHERE r.product_id = p.id AND r.variant_id IS NULL AND r.status = 'active' AND r.expires_at > NOW()), 0) AS available,
+    p.low_stock_threshold
+FROM products p
+WHERE COALESCE(p.is_active, true) AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND v.is_active)
+UNION ALL
+SELECT p.id, v.id, p.name,
+    COALESCE((SELECT string_agg(v.options->>ot.name, ' / ' ORDER BY ot.position) FROM product_option_types ot WHERE ot.product_id = v.product_id), ''),
+    v.sku, v.stock,
+    v.stock - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r
+        WHERE r.variant_id = v.id AND r.status = 'active' AND r.expires_at > NOW()), 0),
+    p.low_stock_threshold
+FROM product_variants v
+JOIN products p ON p.id = v.product_id
+WHERE COALESCE(p.is_active, true) AND v.is_active;
@@ -0,0 +1,8 @@
+-- Payments M-Pesa reported as successful that could not be applied to their
+-- order (hold expired, stock ran out, order cancelled). The money has been
+-- taken, so staff must refund it or fulfil the order by hand.
+ALTER TABLE transactions ADD COLUMN review_reason TEXT;
+ALTER TABLE transactions ADD COLUMN reviewed_at TIMESTAMP;
+ALTER TABLE transactions ADD COLUMN reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_transactions_review ON transactions(created_at) WHERE review_reason IS NOT NULL AND reviewed_at IS NULL;
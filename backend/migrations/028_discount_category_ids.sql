@@ -0,0 +1,25 @@
+-- Coupons and promotions target categories by ID so renames keep working and
+-- subcategories are covered. Names that no longer match a category are kept
+-- as they are, which leaves the discount restricted rather than open to
+-- every product.
+UPDATE coupons SET categories = ARRAY(
+    SELECT COALESCE(
+        (SELECT c.id::text FROM categories c
+         WHERE LOWER(c.name) = LOWER(TRIM(ref)) OR c.slug = TRIM(BOTH '-' FROM regexp_replace(LOWER(TRIM(ref)), '[^a-z0-9]+', '-', 'g'))
+         ORDER BY c.parent_id NULLS FIRST LIMIT 1),
+        ref)
+    FROM unnest(categories) WITH ORDINALITY AS t(ref, n)
+    ORDER BY n
+)
+WHERE categories <> '{}';
+
+UPDATE promotions SET categories = ARRAY(
+    SELECT COALESCE(
+        (SELECT c.id::text FROM categories c
+         WHERE LOWER(c.name) = LOWER(TRIM(ref)) OR c.slug = TRIM(BOTH '-' FROM regexp_replace(LOWER(TRIM(ref)), '[^a-z0-9]+', '-', 'g'))
+         ORDER BY c.parent_id NULLS FIRST LIMIT 1),
+        ref)
+    FROM unnest(categories) WITH ORDINALITY AS t(ref, n)
+    ORDER BY n
+)
+WHERE categories <> '{}';